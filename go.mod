@@ -1,5 +1,13 @@
 module github.com/tvastar/gogo
 
-go 1.12
+go 1.22.0
 
-require github.com/google/go-cmp v0.3.1
+require (
+	github.com/google/go-cmp v0.6.0
+	golang.org/x/tools v0.26.0
+)
+
+require (
+	golang.org/x/mod v0.21.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+)
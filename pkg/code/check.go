@@ -0,0 +1,181 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Check marshals the file and type-checks it using go/types.  The
+// imports are resolved with the provided importer, which defaults
+// to one that type-checks the dependencies from source.
+//
+// Each error is reported with the position in the rendered file and
+// the builder that created the node at fault:
+//
+//	generated.go:5:9: undefined: y (Ident: y)
+func Check(m NodeMarshaler, imp types.Importer) error {
+	s := RootScope()
+	s.Stash[&originsKey] = origins{}
+	node, err := MarshalNodeErr(m, s)
+	if err != nil {
+		return err
+	}
+	f, ok := node.(*ast.File)
+	if !ok {
+		return fmt.Errorf("code: cannot check %T, expected a file", node)
+	}
+	return check(s, f.Name.Name, map[string]*ast.File{"generated.go": f}, imp)
+}
+
+// Check marshals all the files of the package and type-checks them
+// together. See the Check function for details.
+func (p *Pkg) Check(imp types.Importer) error {
+	s := RootScope()
+	s.Stash[&originsKey] = origins{}
+	files := map[string]*ast.File{}
+	for _, f := range p.Files {
+		node, err := MarshalNodeErr(File(p.Name, f.Contents...), fileScope(s))
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		files[f.Name] = node.(*ast.File)
+	}
+	return check(s, p.Name, files, imp)
+}
+
+func check(s *Scope, pkgName string, files map[string]*ast.File, imp types.Importer) error {
+	fset := token.NewFileSet()
+	if imp == nil {
+		imp = importer.ForCompiler(fset, "source", nil)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// parse the rendered files, remembering the generated node of
+	// each parsed node
+	generated := map[ast.Node]ast.Node{}
+	parsed := make([]*ast.File, len(names))
+	for kk, name := range names {
+		src, err := formatFile(files[name], localPrefix(s, files[name]))
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if parsed[kk], err = parser.ParseFile(fset, name, src, 0); err != nil {
+			return err
+		}
+		err = walkPairs(files[name], parsed[kk], func(n1, n2 ast.Node) {
+			generated[n2] = n1
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	var errs Errors
+	cfg := &types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			terr, ok := err.(types.Error)
+			if !ok {
+				errs = append(errs, err)
+				return
+			}
+			msg := fmt.Sprintf("%s: %s", fset.Position(terr.Pos), terr.Msg)
+			if o := origin(s, parsed, generated, terr.Pos); o != "" {
+				msg += " (" + o + ")"
+			}
+			errs = append(errs, fmt.Errorf("%s", msg))
+		},
+	}
+	// the errors are collected by cfg.Error
+	_, _ = cfg.Check(pkgName, fset, parsed, nil)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// origin finds the innermost node at pos which has a known builder
+// and describes it
+func origin(s *Scope, files []*ast.File, generated map[ast.Node]ast.Node, pos token.Pos) string {
+	builders := s.Stash[&originsKey].(origins)
+	result := ""
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			if n == nil || pos < n.Pos() || pos >= n.End() {
+				return false
+			}
+			if builder, ok := builders[generated[n]]; ok {
+				result = builder + ": " + summary(generated[n])
+			}
+			return true
+		})
+	}
+	return result
+}
+
+// origins tracks the builder which created each node.  It is stashed
+// in the root scope while checking.
+type origins map[ast.Node]string
+
+var originsKey = "origins"
+
+// track records the builder of the node if origins are being tracked
+func track(s *Scope, n nodef, node ast.Node) {
+	x, ok := s.LookupStash(&originsKey)
+	if !ok || node == nil {
+		return
+	}
+	if o := x.(origins); o[node] == "" {
+		name := runtime.FuncForPC(reflect.ValueOf(n).Pointer()).Name()
+		o[node] = builderName(name)
+	}
+}
+
+// summary is the first line of the formatted node.  Partially built
+// nodes may not be printable, in which case the type is used.
+func summary(n ast.Node) (result string) {
+	defer func() {
+		if recover() != nil {
+			result = fmt.Sprintf("%T", n)
+		}
+	}()
+
+	// doc comments cannot be placed without positions
+	switch x := n.(type) {
+	case *ast.GenDecl:
+		bare := *x
+		bare.Doc, n = nil, &bare
+	case *ast.FuncDecl:
+		bare := *x
+		bare.Doc, n = nil, &bare
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), n); err != nil {
+		return fmt.Sprintf("%T", n)
+	}
+	line := strings.SplitN(buf.String(), "\n", 2)[0]
+	if len(line) > 40 {
+		line = line[:40] + "..."
+	}
+	return line
+}
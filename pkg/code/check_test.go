@@ -0,0 +1,51 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/tvastar/gogo/pkg/code"
+
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	valid := code.File("x",
+		code.Var().WithValue(code.Ident("b"), code.Import("strings").Dot("Builder"), nil),
+		code.Var().WithValue(code.Ident("n"), nil, code.Ident("b").Dot("Len").Call()),
+	)
+	if err := code.Check(valid, nil); err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	invalid := code.File("x",
+		code.Var().WithValue(code.Ident("a"), nil, code.Ident("y").Op("+", code.Literal(1))),
+		code.Var().WithValue(code.Ident("b"), code.Ident("int"), code.Literal(`"s"`)),
+	)
+	err := code.Check(invalid, nil)
+	expected := "generated.go:3:9: undefined: y (Ident: y)\n" +
+		"generated.go:4:13: cannot use \"s\" (untyped string constant) as int value in variable declaration (Literal: \"s\")"
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+	if diff := cmp.Diff(expected, err.Error()); diff != "" {
+		t.Error("mismatch", diff)
+	}
+}
+
+func TestPkgCheck(t *testing.T) {
+	pkg := code.Package("x").
+		WithFile("a.go", code.Var().WithValue(code.Ident("A"), nil, code.Literal(1))).
+		WithFile("b.go", code.Var().WithValue(code.Ident("B"), nil, code.Ident("A").Op("+", code.Literal(`"x"`))))
+
+	err := pkg.Check(nil)
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+	expected := "b.go:3:9: invalid operation: A + \"x\" (mismatched types int and untyped string) (Ident: A)"
+	if diff := cmp.Diff(expected, err.Error()); diff != "" {
+		t.Error("mismatch", diff)
+	}
+}
@@ -26,9 +26,8 @@ import (
 	"go/ast"
 	"go/token"
 	"strconv"
-	"strings"
-
-	"golang.org/x/tools/go/packages"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Nil is the nil literal
@@ -63,6 +62,35 @@ func Literal(v interface{}) NodeMarshaler {
 	})
 }
 
+// Composite is a composite literal of the provided type. The
+// elements can be positional or created using KeyValue:
+//
+//     code.Composite(code.Ident("Foo"), code.KeyValue(code.Ident("A"), code.Literal(1))).Addr()
+//
+// The type can be nil for elements of an enclosing composite literal.
+func Composite(typ NodeMarshaler, elts ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		result := &ast.CompositeLit{}
+		if typ != nil {
+			result.Type = expr(s, typ)
+		}
+		for _, elt := range elts {
+			result.Elts = append(result.Elts, expr(s, elt))
+		}
+		return result
+	})
+}
+
+// KeyValue is a keyed element of a composite literal
+func KeyValue(key, value NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.KeyValueExpr{
+			Key:   expr(s, key),
+			Value: expr(s, value),
+		}
+	})
+}
+
 // Ident is a specific identifier
 func Ident(s string) NodeMarshaler {
 	return nodef(func(*Scope) ast.Node {
@@ -72,68 +100,144 @@ func Ident(s string) NodeMarshaler {
 
 // IdentPrefix is an unique local identifier using the provided prefix
 // which should not be empty
+//
+// The name is picked when the identifier is first marshaled and it
+// is bound to that scope: it does not shadow any variable visible
+// there and all uses within the scope refer to the same name.  The
+// same marshaler can be reused with other scopes (such as another
+// file) and a fresh name is picked for each.
+//
+// It is an error to use the identifier where the name refers to a
+// different variable declared in a nested scope.
 func IdentPrefix(prefix string) NodeMarshaler {
-	var name string
+	key := &struct{ prefix string }{prefix}
 	return nodef(func(s *Scope) ast.Node {
-		if name == "" {
-			name = s.PickName(prefix)
-			s.Vars[name] = ast.NewIdent(name)
-			return s.Vars[name]
+		if v, ok := s.LookupStash(key); ok {
+			id := v.(*ast.Ident)
+			if bound, _ := s.LookupVar(id.Name); bound != id {
+				s.Errorf("%s is shadowed", id.Name)
+			}
+			return id
 		}
-		return ast.NewIdent(name)
-	})
 
+		id := ast.NewIdent(s.PickName(prefix))
+		s.Vars[id.Name] = id
+		s.Stash[key] = id
+		return id
+	})
 }
 
 // If represents an if statement.  For the variant with assignment,
 // use the If2 function.
 func If(cond NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		return &ast.IfStmt{Cond: cond.MarshalNode(s).(ast.Expr)}
+		return &ast.IfStmt{Cond: expr(s, cond)}
 	})
 }
 
 // If2 represents an if statment with an assignment and a condition.
 func If2(let, cond NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		var init ast.Stmt
-		n := let.MarshalNode(s)
-		if nx, ok := n.(ast.Stmt); ok {
-			init = nx
-		} else {
-			init = &ast.ExprStmt{X: n.(ast.Expr)}
-		}
 		return &ast.IfStmt{
-			Init: init,
-			Cond: cond.MarshalNode(s).(ast.Expr),
+			Init: stmt(s, let),
+			Cond: expr(s, cond),
 		}
 	})
 }
 
 // Assign represents an assigment statement.
 //
-// Op can be "+=" or ":=" etc.
+// Op can be "+=" or ":=" etc. The args are pairs of the variable
+// and its value.
+//
+// The variables declared with ":=" are added to the scope.  It is
+// an error if none of them are new in the scope.
 func Assign(op string, kvpairs ...NodeMarshaler) NodeMarshaler {
+	tok := opToken(op)
+	return nodef(func(s *Scope) ast.Node {
+		existing := varNames(s)
+		if len(kvpairs)%2 != 0 {
+			s.Errorf("expected pairs of variables and values but got %d args", len(kvpairs))
+			return &ast.BadStmt{}
+		}
+		var lhs, rhs []ast.Expr
+		for k := 0; k < len(kvpairs); k += 2 {
+			lhs = append(lhs, expr(s, kvpairs[k]))
+			rhs = append(rhs, expr(s, kvpairs[1+k]))
+		}
+		return assign(s, existing, tok, lhs, rhs, rhs)
+	})
+
+}
+
+// assign creates the assignment statement.  With ":=", the new
+// variables are added to the scope using the corresponding values
+// for their types.
+//
+// The existing names of the scope must be collected before the
+// left hand side is marshaled.
+func assign(s *Scope, existing map[string]bool, tok token.Token, lhs, rhs, values []ast.Expr) *ast.AssignStmt {
+	result := &ast.AssignStmt{Lhs: lhs, Tok: tok, Rhs: rhs}
+	if tok != token.DEFINE {
+		return result
+	}
+	if declare(s, existing, lhs...) == 0 {
+		s.Errorf("no new variables on left side of :=")
+	}
+	for kk, l := range lhs {
+		if id, ok := l.(*ast.Ident); ok && !existing[id.Name] && id.Name != "_" {
+			s.declareVar(id.Name, nil, values[kk])
+		}
+	}
+	return result
+}
+
+// varNames returns the names of the variables of the scope
+func varNames(s *Scope) map[string]bool {
+	result := map[string]bool{}
+	for name := range s.Vars {
+		result[name] = true
+	}
+	return result
+}
+
+// opToken converts the op into a token
+func opToken(op string) token.Token {
 	var tok token.Token
 	for kk := token.ILLEGAL; kk <= token.VAR; kk++ {
 		if kk.String() == op {
 			tok = kk
 		}
 	}
-	return nodef(func(s *Scope) ast.Node {
-		result := &ast.AssignStmt{Tok: tok}
-		for k := 0; k < len(kvpairs); k += 2 {
-			// TODO: update scope.Vars
-			l, r := kvpairs[k].MarshalNode(s), kvpairs[1+k].MarshalNode(s)
-			result.Lhs = append(result.Lhs, l.(ast.Expr))
-			result.Rhs = append(result.Rhs, r.(ast.Expr))
-		}
-		return result
-	})
+	return tok
+}
 
+// declare adds the identifiers to the scope, returning the number
+// of names which did not exist before
+func declare(s *Scope, existing map[string]bool, exprs ...ast.Expr) int {
+	count := 0
+	for _, x := range exprs {
+		if x == nil {
+			continue
+		}
+		id, ok := x.(*ast.Ident)
+		if !ok {
+			s.Errorf("non-name %s on left side of :=", summary(x))
+			continue
+		}
+		if id.Name != "_" && !existing[id.Name] {
+			s.Vars[id.Name] = id
+			count++
+		}
+	}
+	return count
 }
 
 // File creates a new ast.File with the provided contents
+//
+// The imports of the file are grouped and sorted the same way
+// goimports does, with unused imports removed. See LocalImports for
+// how to configure the local imports group.
 func File(pkgName string, contents ...NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
 		f := &ast.File{Name: ast.NewIdent(pkgName)}
@@ -149,10 +253,17 @@ func File(pkgName string, contents ...NodeMarshaler) NodeMarshaler {
 				f.Decls = append(f.Decls, n)
 			case *ast.ImportSpec:
 				f.Imports = append(f.Imports, n)
+			case *ast.CommentGroup:
+				f.Comments = append(f.Comments, n)
 			default:
-				panic("unexpected file content type")
+				s.unexpected(content, n, "a declaration")
 			}
 		}
+
+		local, _ := s.LookupStash(&localKey)
+		prefix, _ := local.(string)
+		cleanImports(f, imports, prefix)
+		setLocalPrefix(s, f, prefix)
 		if len(imports.Specs) == 0 {
 			f.Decls = f.Decls[1:]
 		}
@@ -161,45 +272,128 @@ func File(pkgName string, contents ...NodeMarshaler) NodeMarshaler {
 }
 
 // Import imports a package if needed. If the package already exists,
-// it uses the same name as it used to have
+// it uses the same name as it used to have unless a variable visible
+// in the scope shadows that name, in which case the package is
+// imported again with another name.
+//
+// The package name is determined using the ImportResolver of the
+// scope. If that name is already used by another import or by a
+// variable visible in the scope, a numeric suffix is added.
 func Import(pkg string) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		path := `"` + pkg + `"`
-		x, _ := s.LookupStash(&fileKey)
-		f := x.(*ast.File)
-		for _, spec := range f.Imports {
-			if spec.Path.Value == path {
-				return ast.NewIdent(spec.Name.Name)
-			}
+		name, err := s.ImportResolver().ResolveImport(pkg)
+		if err != nil {
+			s.Errorf("cannot resolve import %s: %v", pkg, err)
+			return &ast.BadExpr{}
 		}
-		cfg := &packages.Config{Mode: packages.NeedName}
-		pkgs, err := packages.Load(cfg, pkg)
-		name := ""
-		if err == nil && len(pkgs) == 1 && pkgs[0].Name != "" {
-			name = pkgs[0].Name
-		} else {
-			parts := strings.Split(pkg, "/")
-			name = parts[len(parts)-1]
+		return addImport(s, pkg, name, false)
+	})
+}
+
+// ImportAs imports a package with the provided name. If the package
+// is already imported with that name, the existing import is used.
+//
+// If the name is already used by another import or by a variable
+// visible in the scope, a numeric suffix is added like with Import.
+func ImportAs(name, pkg string) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return addImport(s, pkg, name, true)
+	})
+}
+
+// ImportBlank imports a package for its side-effects:
+//
+//     import _ "embed"
+//
+// It is meant to be used within File and marshals to nil.
+func ImportBlank(pkg string) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		f := currentFile(s)
+		if findImport(f, pkg, "") == nil {
+			appendImport(f, pkg, "_", true)
 		}
-		idx := 0
-		uniq := name
-		for !isUniqueImport(f, uniq) {
-			idx++
-			uniq = name + strconv.Itoa(idx)
+		return nil
+	})
+}
+
+// ImportDot imports all the exported names of a package into the
+// file:
+//
+//     import . "math"
+//
+// It is meant to be used within File and marshals to nil.
+func ImportDot(pkg string) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		f := currentFile(s)
+		if findImport(f, pkg, ".") == nil {
+			appendImport(f, pkg, ".", true)
 		}
+		return nil
+	})
+}
 
-		spec := &ast.ImportSpec{
-			Name: ast.NewIdent(uniq),
-			Path: &ast.BasicLit{Kind: token.STRING, Value: path},
+// addImport imports pkg with the provided name (adding a suffix if
+// needed) and returns the identifier to refer to the package by.
+//
+// If explicit is not set, an existing import of the package is used
+// if there is one.
+func addImport(s *Scope, pkg, name string, explicit bool) ast.Node {
+	f := currentFile(s)
+	want := ""
+	if explicit {
+		want = name
+	}
+	// imports shadowed by a variable cannot be used here
+	for _, spec := range f.Imports {
+		if importMatches(spec, pkg, want) && !isVar(s, spec.Name.Name) {
+			return ast.NewIdent(spec.Name.Name)
 		}
-		f.Imports = append(f.Imports, spec)
-		imports := f.Decls[0].(*ast.GenDecl)
-		if uniq == name {
-			spec = &ast.ImportSpec{Path: spec.Path}
+	}
+
+	idx := 0
+	uniq := name
+	for !isUniqueImport(f, uniq) || isVar(s, uniq) {
+		idx++
+		uniq = name + strconv.Itoa(idx)
+	}
+	appendImport(f, pkg, uniq, explicit || uniq != name)
+	return ast.NewIdent(uniq)
+}
+
+// findImport finds the import of pkg with the provided name. If
+// name is empty, any import that can be referred to by name is
+// returned.
+func findImport(f *ast.File, pkg, name string) *ast.ImportSpec {
+	for _, spec := range f.Imports {
+		if importMatches(spec, pkg, name) {
+			return spec
 		}
-		imports.Specs = append(imports.Specs, spec)
-		return ast.NewIdent(uniq)
-	})
+	}
+	return nil
+}
+
+// importMatches checks if the spec imports pkg with the provided
+// name, or with any usable name if name is empty
+func importMatches(spec *ast.ImportSpec, pkg, name string) bool {
+	if spec.Path.Value != strconv.Quote(pkg) {
+		return false
+	}
+	return spec.Name.Name == name || name == "" && spec.Name.Name != "_" && spec.Name.Name != "."
+}
+
+// appendImport adds the import to the file. File.Imports always
+// has the name but the import decl only has it if it is explicit.
+func appendImport(f *ast.File, pkg, name string, explicit bool) {
+	spec := &ast.ImportSpec{
+		Name: ast.NewIdent(name),
+		Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(pkg)},
+	}
+	f.Imports = append(f.Imports, spec)
+	imports := f.Decls[0].(*ast.GenDecl)
+	if !explicit {
+		spec = &ast.ImportSpec{Path: spec.Path}
+	}
+	imports.Specs = append(imports.Specs, spec)
 }
 
 func isUniqueImport(f *ast.File, name string) bool {
@@ -211,24 +405,178 @@ func isUniqueImport(f *ast.File, name string) bool {
 	return true
 }
 
+func isVar(s *Scope, name string) bool {
+	_, ok := s.LookupVar(name)
+	return ok
+}
+
+// isImport checks if the name is used by an import of the current
+// file
+func isImport(s *Scope, name string) bool {
+	f, ok := s.LookupStash(&fileKey)
+	return ok && !isUniqueImport(f.(*ast.File), name)
+}
+
+// currentFile returns the file being marshaled. Outside of a File,
+// an error is reported and a throwaway file is returned.
+func currentFile(s *Scope) *ast.File {
+	if x, ok := s.LookupStash(&fileKey); ok {
+		return x.(*ast.File)
+	}
+	s.Errorf("not within a File")
+	return &ast.File{Decls: []ast.Decl{&ast.GenDecl{Tok: token.IMPORT}}}
+}
+
+// fileLevel returns the scope of the enclosing File which holds the
+// package level names, or s itself outside of a File
+func fileLevel(s *Scope) *Scope {
+	for x := s; x != nil; x = x.Parent {
+		if _, ok := x.Stash[&fileKey]; ok {
+			return x
+		}
+	}
+	return s
+}
+
 // AddDecl adds a declaration to the current file
 func AddDecl(s *Scope, n NodeMarshaler) {
-	x, _ := s.LookupStash(&fileKey)
-	f := x.(*ast.File)
-	f.Decls = append(f.Decls, n.MarshalNode(s).(ast.Decl))
+	f := currentFile(s)
+	node := n.MarshalNode(s)
+	if decl, ok := node.(ast.Decl); ok {
+		f.Decls = append(f.Decls, decl)
+	} else {
+		s.unexpected(n, node, "a declaration")
+	}
 }
 
 // Func creates a func decl.
+//
+// Use Method for methods.
 func Func(name string) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		s.Vars[name] = ast.NewIdent(name)
+		fn := &ast.FuncDecl{
+			Name: ast.NewIdent(name),
+			Type: &ast.FuncType{
+				Params:  &ast.FieldList{},
+				Results: &ast.FieldList{},
+			},
+		}
+		return fn
+	})
+}
+
+// declareFunc records the name of a top-level func in the scope of
+// the enclosing File as it is package level, unlike the params.
+// Methods and the method signatures of interfaces are not recorded.
+func declareFunc(s *Scope, fn *ast.FuncDecl) {
+	if fn.Recv == nil {
+		pkg := fileLevel(s)
+		pkg.Vars[fn.Name.Name] = fn.Name
+		pkg.declareVar(fn.Name.Name, fn.Type, nil)
+	}
+}
+
+// Method creates a method declaration. The receiver type can be a
+// pointer:
+//
+//	code.Method(code.Ident("Point").Star(), "String").
+//	    WithResult(nil, code.Ident("string"), nil).
+//	    WithBody(code.Return(code.Receiver().Dot("Name")))
+//
+// The receiver is named after the first letter of the type ("p"
+// for Point) unless that is already in use.  If a param is later
+// added with the same name, the receiver is renamed.  Use Receiver
+// to refer to it.
+func Method(recvType NodeMarshaler, name string) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		typ := expr(s, recvType)
+		recv := ast.NewIdent(s.PickName(receiverName(typ)))
+		s.Vars[recv.Name] = recv
+		s.Stash[&receiverKey] = recv
+		s.declareVar(recv.Name, typ, nil)
 		return &ast.FuncDecl{
 			Name: ast.NewIdent(name),
-			Recv: &ast.FieldList{},
+			Recv: &ast.FieldList{List: []*ast.Field{{
+				Names: []*ast.Ident{recv},
+				Type:  typ,
+			}}},
+			Type: &ast.FuncType{
+				Params:  &ast.FieldList{},
+				Results: &ast.FieldList{},
+			},
+		}
+	})
+}
+
+// Receiver refers to the receiver of the enclosing Method.  It is
+// an error if a variable with the same name shadows the receiver.
+func Receiver() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		if v, ok := s.LookupStash(&receiverKey); ok {
+			recv := v.(*ast.Ident)
+			if bound, _ := s.LookupVar(recv.Name); bound != recv {
+				s.Errorf("receiver %s is shadowed", recv.Name)
+			}
+			return recv
+		}
+		s.Errorf("not within a Method")
+		return &ast.BadExpr{}
+	})
+}
+
+// receiverName is the conventional receiver name for the type: the
+// lower case first letter of the type name
+func receiverName(typ ast.Expr) string {
+	for {
+		switch x := typ.(type) {
+		case *ast.StarExpr:
+			typ = x.X
+		case *ast.IndexExpr:
+			typ = x.X
+		case *ast.IndexListExpr:
+			typ = x.X
+		case *ast.SelectorExpr:
+			typ = x.Sel
+		case *ast.Ident:
+			r, _ := utf8.DecodeRuneInString(x.Name)
+			return string(unicode.ToLower(r))
+		default:
+			return "recv"
+		}
+	}
+}
+
+// renameReceiver renames the receiver of the enclosing method if it
+// collides with the name of a param declared in the same scope
+func renameReceiver(s *Scope, name string) {
+	recv, ok := s.Stash[&receiverKey].(*ast.Ident)
+	if ok && recv.Name == name && s.Vars[name] == recv {
+		delete(s.Vars, name)
+		recv.Name = s.PickName(name + name)
+		s.Vars[recv.Name] = recv
+		s.typeInfo().vars[recv.Name] = s.typeInfo().vars[name]
+	}
+}
+
+var receiverKey = "receiver"
+
+// Lambda creates a func literal. The params, results and body are
+// added the same way as with Func:
+//
+//     code.Lambda().
+//         WithParam(code.Ident("i"), code.Ident("int"), nil).
+//         WithResult(nil, code.Ident("bool"), nil).
+//         WithBody(...)
+//
+// The body can refer to variables of the enclosing scope.
+func Lambda() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.FuncLit{
 			Type: &ast.FuncType{
 				Params:  &ast.FieldList{},
 				Results: &ast.FieldList{},
 			},
+			Body: &ast.BlockStmt{},
 		}
 	})
 }
@@ -238,7 +586,7 @@ func Return(args ...NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
 		stmt := &ast.ReturnStmt{}
 		for _, arg := range args {
-			x := arg.MarshalNode(s).(ast.Expr)
+			x := expr(s, arg)
 			stmt.Results = append(stmt.Results, x)
 		}
 		return stmt
@@ -9,51 +9,673 @@ import (
 	"github.com/tvastar/gogo/pkg/code"
 
 	"bytes"
+	"go/ast"
 	"go/format"
+	"go/importer"
 	"go/token"
+	"go/types"
+	"strings"
 	"testing"
 )
 
 func TestExpr(t *testing.T) {
-	validate := func(test string, expr string, noder code.NodeMarshaler) {
-		var buf bytes.Buffer
-		result := noder.MarshalNode(code.RootScope())
-		if err := format.Node(&buf, &token.FileSet{}, result); err != nil {
-			t.Fatal("format error", err)
-		}
-		if diff := cmp.Diff(expr, buf.String()); diff != "" {
-			t.Error("mismatch", diff)
-		}
-	}
-
-	validate("ident", "x", code.Ident("x"))
-	validate("literal int", "5", code.Literal(5))
-	validate("literal int", "-5", code.Literal(-5))
-	validate("literal float", "5.2", code.Literal(5.2))
-	validate("literal chara", "'x'", code.Rune('x'))
-	validate("+", "x + y", code.Ident("x").Op("+", code.Ident("y")))
-	validate("unary", "-x", code.Ident("x").Op("-", nil))
-	validate("<", "x < y", code.Ident("x").Op("<", code.Ident("y")))
-	validate("ident prefix", "x < x2", code.IdentPrefix("x").Op("<", code.IdentPrefix("x")))
+	validate(t, "ident", "x", code.Ident("x"))
+	validate(t, "literal int", "5", code.Literal(5))
+	validate(t, "literal int", "-5", code.Literal(-5))
+	validate(t, "literal float", "5.2", code.Literal(5.2))
+	validate(t, "literal chara", "'x'", code.Rune('x'))
+	validate(t, "+", "x + y", code.Ident("x").Op("+", code.Ident("y")))
+	validate(t, "unary", "-x", code.Ident("x").Op("-", nil))
+	validate(t, "<", "x < y", code.Ident("x").Op("<", code.Ident("y")))
+	validate(t, "ident prefix", "x < x2", code.IdentPrefix("x").Op("<", code.IdentPrefix("x")))
 	x2 := code.IdentPrefix("x")
-	validate("ident prefix", "x < x2 < x2", code.IdentPrefix("x").Op("<", x2).Op("<", x2))
-	validate("<=", "x <= y", code.Ident("x").Op("<=", code.Ident("y")))
+	validate(t, "ident prefix", "x < x2 < x2", code.IdentPrefix("x").Op("<", x2).Op("<", x2))
+	x2 = code.IdentPrefix("x")
+	validate(t, "<=", "x <= y", code.Ident("x").Op("<=", code.Ident("y")))
 
-	validate("()", "(x)", code.Ident("x").Paren())
-	validate("call", "x()", code.Ident("x").Call())
-	validate("call", "x(y)", code.Ident("x").Call(code.Ident("y")))
-	validate("nil", "nil", code.Nil())
-	validate("then", "if x {\n\ty\n}", code.If(code.Ident("x")).Then(code.Ident("y")))
+	validate(t, "()", "(x)", code.Ident("x").Paren())
+	validate(t, "call", "x()", code.Ident("x").Call())
+	validate(t, "call", "x(y)", code.Ident("x").Call(code.Ident("y")))
+	validate(t, "nil", "nil", code.Nil())
+	validate(t, "then", "if x {\n\ty\n}", code.If(code.Ident("x")).Then(code.Ident("y")))
 
-	validate("then2", "if x {\n\ty\n\tz\n}",
+	validate(t, "then2", "if x {\n\ty\n\tz\n}",
 		code.If(code.Ident("x")).
 			Then(code.Ident("y"), code.Ident("z")))
 
-	validate("assign", "x = y", code.Assign("=", code.Ident("x"), code.Ident("y")))
+	validate(t, "else", "if x {\n\ty\n} else {\n\tz\n}",
+		code.If(code.Ident("x")).Then(code.Ident("y")).Else(code.Ident("z")))
+
+	validate(t, "else if", "if x {\n\ty\n} else if y {\n\tz\n} else if z {\n\tx\n} else {\n\ty\n}",
+		code.If(code.Ident("x")).Then(code.Ident("y")).
+			ElseIf(code.Ident("y")).Then(code.Ident("z")).
+			ElseIf(code.Ident("z")).Then(code.Ident("x")).
+			Else(code.Ident("y")))
+
+	v := code.IdentPrefix("v")
+	validate(t, "else scope", "if v := n; v {\n\tv2\n} else {\n\tv\n}",
+		code.If2(v.Assign(":=", code.Ident("n")), v).
+			Then(code.IdentPrefix("v")).
+			Else(v))
+
+	validate(t, "lambda", "func(x int) bool {\n\treturn x < y\n}",
+		code.Lambda().
+			WithParam(code.Ident("x"), code.Ident("int"), nil).
+			WithResult(nil, code.Ident("bool"), nil).
+			WithBody(code.Return(code.Ident("x").Op("<", code.Ident("y")))))
+
+	validate(t, "lambda capture", "func f(x int) {\n\tsort.Slice(x, func(x2 int) {\n\t\tx2 < x\n\t})\n}",
+		code.Func("f").WithParam(code.Ident("x"), code.Ident("int"), nil).WithBody(
+			code.Ident("sort").Dot("Slice").Call(code.Ident("x"), code.Lambda().
+				WithParam(x2, code.Ident("int"), nil).
+				WithBody(x2.Op("<", code.Ident("x"))))))
 
-	validate("if2", "if x := n; x < y {\n\tz\n}",
+	validate(t, "composite", "&Foo{A: 1, B: x}",
+		code.Composite(code.Ident("Foo"),
+			code.KeyValue(code.Ident("A"), code.Literal(1)),
+			code.KeyValue(code.Ident("B"), code.Ident("x"))).Addr())
+	validate(t, "composite positional", "T{1, 2}",
+		code.Composite(code.Ident("T"), code.Literal(1), code.Literal(2)))
+	validate(t, "composite nested", "T{\"a\": {1}, \"b\": {}}",
+		code.Composite(code.Ident("T"),
+			code.KeyValue(code.Literal(`"a"`), code.Composite(nil, code.Literal(1))),
+			code.KeyValue(code.Literal(`"b"`), code.Composite(nil))))
+	validate(t, "addr", "&x", code.Ident("x").Addr())
+
+	validate(t, "assign", "x = y", code.Assign("=", code.Ident("x"), code.Ident("y")))
+
+	validate(t, "if2", "if x := n; x < y {\n\tz\n}",
 		code.If2(code.Assign(":=", code.Ident("x"), code.Ident("n")),
 			code.Ident("x").Op("<", code.Ident("y"))).
 			Then(code.Ident("z")))
 
 }
+
+func TestIdentPrefix(t *testing.T) {
+	x := code.IdentPrefix("x")
+	shadow := code.Func("f").WithParam(code.Ident("x"), code.Ident("int"), nil).WithBody(
+		x.Assign(":=", code.Literal(1)),
+		x.Op("+", code.Ident("x")),
+	)
+	for _, name := range []string{"a.go", "b.go"} {
+		src, err := code.Render(code.File("x", shadow))
+		if err != nil {
+			t.Fatal("unexpected error", name, err)
+		}
+		expected := "package x\n\nfunc f(x int) {\n\tx2 := 1\n\tx2 + x\n}\n"
+		if diff := cmp.Diff(expected, string(src)); diff != "" {
+			t.Error("mismatch", name, diff)
+		}
+	}
+
+	_, err := code.Render(code.Func("f").WithBody(
+		x.Assign(":=", code.Literal(1)),
+		code.If(code.Ident("y")).Then(
+			code.Stmt("x := 2"),
+			x,
+		),
+	))
+	if err == nil || !strings.Contains(err.Error(), "x is shadowed") {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestScopeTracking(t *testing.T) {
+	validateRender(t, "define", "func f() {\n\tx, y := 1, 2\n\tx2, y2 := x, y\n}",
+		code.Func("f").WithBody(
+			code.Assign(":=", code.Ident("x"), code.Literal(1), code.Ident("y"), code.Literal(2)),
+			code.Assign(":=", code.IdentPrefix("x"), code.Ident("x"), code.IdentPrefix("y"), code.Ident("y")),
+		))
+
+	validateRender(t, "range", "func f() {\n\tfor k, v := range m {\n\t\tk2 := k + v\n\t}\n}",
+		code.Func("f").WithBody(
+			code.Range(":=", code.Ident("k"), code.Ident("v"), code.Ident("m")).WithBody(
+				code.Assign(":=", code.IdentPrefix("k"), code.Ident("k").Op("+", code.Ident("v"))),
+			),
+		))
+
+	validateRender(t, "partial redeclare", "func f() {\n\tx := 1\n\tx, y := 2, 3\n}",
+		code.Func("f").WithBody(
+			code.Assign(":=", code.Ident("x"), code.Literal(1)),
+			code.Assign(":=", code.Ident("x"), code.Literal(2), code.Ident("y"), code.Literal(3)),
+		))
+
+	_, err := code.Render(code.Func("f").WithBody(
+		code.Assign(":=", code.Ident("x"), code.Literal(1)),
+		code.Assign(":=", code.Ident("x"), code.Literal(2), code.Ident("_"), code.Literal(3)),
+	))
+	if err == nil || !strings.Contains(err.Error(), "no new variables on left side of :=") {
+		t.Error("unexpected error", err)
+	}
+
+	// params and the receiver are in the scope of the body
+	_, err = code.Render(code.Func("f").
+		WithReceiver(code.Ident("r"), code.Ident("T"), nil).
+		WithParam(code.Ident("x"), code.Ident("int"), nil).
+		WithBody(
+			code.Assign(":=", code.Ident("x"), code.Literal(1)),
+			code.Assign(":=", code.Ident("r"), code.Literal(2)),
+		))
+	expected := "code: Assign: no new variables on left side of :=\n" +
+		"code: Assign: no new variables on left side of :="
+	if err == nil || err.Error() != expected {
+		t.Error("unexpected error", err)
+	}
+
+	validateRender(t, "nested redeclare", "func(x int) {\n\tfor {\n\t\tx := 1\n\t}\n}",
+		code.Lambda().WithParam(code.Ident("x"), code.Ident("int"), nil).WithBody(
+			code.For(nil, nil, nil).WithBody(code.Assign(":=", code.Ident("x"), code.Literal(1))),
+		))
+}
+
+func TestTypeOf(t *testing.T) {
+	seen := map[string]string{}
+	probe := func(names ...string) code.NodeMarshaler {
+		return code.MarshalerFunc(func(s *code.Scope) ast.Node {
+			for _, name := range names {
+				seen[name] = types.TypeString(s.TypeOf(name), nil)
+			}
+			return code.Comment("probe").MarshalNode(s)
+		})
+	}
+
+	imp := importer.ForCompiler(token.NewFileSet(), "source", nil)
+	file := code.UseImporter(imp, code.File("x",
+		code.Struct("Point").WithField(code.Ident("X"), code.Ident("int"), nil),
+		code.Var().WithValue(code.Ident("b"), code.Import("strings").Dot("Builder"), nil),
+		code.Func("f").
+			WithParam(code.Ident("p"), code.Ident("Point").Star(), nil).
+			WithParam(code.Ident("items"), code.MapOf(code.Ident("string"), code.Ident("bool")), nil).
+			WithResult(nil, code.Ident("error"), nil).
+			WithBody(
+				code.Range(":=", code.Ident("k"), code.Ident("v"), code.Ident("items")).WithBody(
+					probe("k", "v"),
+				),
+				code.Assign(":=", code.Ident("n"), code.Ident("len").Call(code.Ident("items"))),
+				code.Assign(":=", code.Ident("x"), code.Ident("p").Dot("X")),
+				code.Assign(":=", code.Ident("l"), code.Ident("b").Dot("Len").Call()),
+				code.Assign(":=", code.Ident("g"), code.Ident("f")),
+				code.Stmt("s := []rune{'a'}"),
+				probe("p", "items", "n", "x", "l", "g", "s", "b", "unknown"),
+			),
+	))
+	if _, err := code.Render(file); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := map[string]string{
+		"k":       "string",
+		"v":       "bool",
+		"p":       "*Point",
+		"items":   "map[string]bool",
+		"n":       "int",
+		"x":       "int",
+		"l":       "int",
+		"g":       "func(p *Point, items map[string]bool) error",
+		"s":       "[]rune",
+		"b":       "strings.Builder",
+		"unknown": "<nil>",
+	}
+	if diff := cmp.Diff(expected, seen); diff != "" {
+		t.Error("mismatch", diff)
+	}
+}
+
+func TestTypeOfBinary(t *testing.T) {
+	seen := map[string]string{}
+	probe := code.MarshalerFunc(func(s *code.Scope) ast.Node {
+		for _, name := range []string{"a", "r", "c", "d", "e", "u"} {
+			seen[name] = types.TypeString(s.TypeOf(name), nil)
+		}
+		return code.Comment("probe").MarshalNode(s)
+	})
+
+	timeout := code.Ident("timeout")
+	imp := importer.ForCompiler(token.NewFileSet(), "source", nil)
+	file := code.UseImporter(imp, code.File("x",
+		code.Func("f").
+			WithParam(timeout, code.Import("time").Dot("Duration"), nil).
+			WithBody(
+				code.Assign(":=", code.Ident("a"), code.Literal(1).Op("+", code.Literal(2.5))),
+				code.Stmt("r := 1 + 'a'"),
+				code.Stmt("c := 2.5 * (1 + 2i)"),
+				code.Assign(":=", code.Ident("d"), code.Literal(2).Op("*", timeout)),
+				code.Assign(":=", code.Ident("e"), timeout.Op("/", code.Literal(2))),
+				code.Assign(":=", code.Ident("u"), code.Ident("unknown").Op("+", code.Literal(1))),
+				probe,
+			),
+	))
+	if _, err := code.Render(file); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := map[string]string{
+		"a": "float64",
+		"r": "rune",
+		"c": "complex128",
+		"d": "time.Duration",
+		"e": "time.Duration",
+		"u": "<nil>",
+	}
+	if diff := cmp.Diff(expected, seen); diff != "" {
+		t.Error("mismatch", diff)
+	}
+}
+
+func TestMethod(t *testing.T) {
+	validateRender(t, "pointer", "func (p *Point) String() string {\n\treturn p.Name\n}",
+		code.Method(code.Ident("Point").Star(), "String").
+			WithResult(nil, code.Ident("string"), nil).
+			WithBody(code.Return(code.Receiver().Dot("Name"))))
+
+	req := code.IdentPrefix("r")
+	validateRender(t, "value", "func (r Router) ServeHTTP(w http.ResponseWriter, r2 *http.Request) {\n\tr.x(r2)\n}",
+		code.Method(code.Ident("Router"), "ServeHTTP").
+			WithParam(code.Ident("w"), code.Ident("http").Dot("ResponseWriter"), nil).
+			WithParam(req, code.Ident("http").Dot("Request").Star(), nil).
+			WithBody(code.Receiver().Dot("x").Call(req)))
+
+	validateRender(t, "param collision", "func (rr Router) ServeHTTP(r int) {\n\trr.x(r)\n}",
+		code.Method(code.Ident("Router"), "ServeHTTP").
+			WithParam(code.Ident("r"), code.Ident("int"), nil).
+			WithBody(code.Receiver().Dot("x").Call(code.Ident("r"))))
+
+	validateRender(t, "generic", "func (l *List[T]) Len() {\n}",
+		code.Method(code.Ident("List").Index(code.Ident("T")).Star(), "Len").WithBody())
+
+	_, err := code.Render(code.Method(code.Ident("T"), "f").WithBody(
+		code.Lambda().
+			WithParam(code.Ident("t"), code.Ident("int"), nil).
+			WithBody(code.Receiver().Dot("x").Call()),
+	))
+	if err == nil || err.Error() != "code: Receiver: receiver t is shadowed" {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	rest, n := code.Ident("rest"), code.IdentPrefix("n")
+	fn := code.Func("f").
+		WithParam(code.Ident("a"), code.Ident("b"), code.Ident("int"), nil).
+		WithVariadic(rest, code.Ident("string")).
+		WithResult(code.Ident("n"), code.Ident("int"), nil).
+		WithResult(code.Ident("err"), code.Ident("error"), nil).
+		WithBody(
+			code.Assign(":=", n, code.Ident("len").Call(rest)),
+			code.Assign("=", code.Ident("n"), n),
+			code.Return(),
+		)
+	src, err := code.Render(fn)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := "func f(a, b int, rest ...string) (n int, err error) {\n\tn2 := len(rest)\n\tn = n2\n\treturn\n}"
+	if diff := cmp.Diff(expected, string(src)); diff != "" {
+		t.Error("mismatch", diff)
+	}
+
+	src, err = code.Render(code.Lambda().
+		WithVariadic(code.Ident("string")).
+		Returns(code.Ident("int"), code.Ident("error")).
+		WithBody())
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if diff := cmp.Diff("func(...string) (int, error) {\n}", string(src)); diff != "" {
+		t.Error("mismatch", diff)
+	}
+
+	_, err = code.Render(code.FuncType().
+		WithVariadic(code.Ident("int")).
+		WithParam(nil, code.Ident("int"), nil).
+		WithResult(code.Ident("n"), code.Ident("int"), nil).
+		Returns(code.Ident("error")))
+	expectedErr := "code: WithParam: param added after the variadic param\n" +
+		"code: Returns: cannot mix named and unnamed results"
+	if err == nil || err.Error() != expectedErr {
+		t.Error("unexpected error", err)
+	}
+
+	_, err = code.Render(code.FuncType().
+		WithParam(code.Ident("a"), code.Ident("int"), nil).
+		WithParam(nil, code.Ident("int"), nil).
+		WithVariadic(code.Ident("string")))
+	expectedErr = "code: WithParam: cannot mix named and unnamed params\n" +
+		"code: WithVariadic: cannot mix named and unnamed params"
+	if err == nil || err.Error() != expectedErr {
+		t.Error("unexpected error", err)
+	}
+
+	// named results are in the scope of the body
+	_, err = code.Render(code.Func("f").
+		WithResult(code.Ident("err"), code.Ident("error"), nil).
+		WithBody(code.Assign(":=", code.Ident("err"), code.Ident("nil"))))
+	if err == nil || err.Error() != "code: Assign: no new variables on left side of :=" {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	jobs, results, wg := code.Ident("jobs"), code.Ident("results"), code.Ident("wg")
+	job, ok := code.IdentPrefix("job"), code.IdentPrefix("ok")
+	fn := code.Func("worker").
+		WithParam(jobs, code.ChanOf(ast.RECV, code.Ident("int")), nil).
+		WithParam(results, code.ChanOf(ast.SEND, code.Ident("int")), nil).
+		WithParam(wg, code.Import("sync").Dot("WaitGroup").Star(), nil).
+		WithBody(
+			code.Defer(wg.Dot("Done").Call()),
+			code.For(nil, nil, nil).WithBody(
+				code.RecvOk(":=", job, ok, jobs),
+				code.If(ok.Op("!", nil)).Then(code.Return()),
+				code.Inc(job),
+				code.Go(code.Lambda().WithBody(code.Send(results, job)).Call()),
+			),
+			code.Assign(":=", code.Ident("last"), code.Recv(results)),
+			code.Dec(code.Ident("last")),
+		)
+	src, err := code.Render(code.File("x", fn))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := `package x
+
+import "sync"
+
+func worker(jobs <-chan int, results chan<- int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		job, ok := <-jobs
+		if !ok {
+			return
+		}
+		job++
+		go func() {
+			results <- job
+		}()
+	}
+	last := <-results
+	last--
+}
+`
+	if diff := cmp.Diff(expected, string(src)); diff != "" {
+		t.Error("mismatch", diff)
+	}
+
+	_, err = code.Render(code.Go(code.Ident("f")))
+	if err == nil || err.Error() != "code: Go: expected a call but Ident produced *ast.Ident (f)" {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	outer, retry := code.Label("outer"), code.Label("retry")
+	row, v := code.IdentPrefix("row"), code.IdentPrefix("v")
+	fn := code.Func("scan").
+		WithParam(code.Ident("rows"), code.SliceOf(code.SliceOf(code.Ident("int"))), nil).
+		WithBody(
+			code.Labeled(retry, code.Assign("=", code.Ident("_"), code.Literal(0))),
+			code.Labeled(outer, code.Range(":=", nil, row, code.Ident("rows")).WithBody(
+				code.Range(":=", nil, v, row).WithBody(
+					code.Switch(nil, v).WithBody(
+						code.Case(code.Literal(0)).Then(code.Fallthrough()),
+						code.Case(code.Literal(1)).Then(code.Continue(outer)),
+						code.Case(code.Literal(2)).Then(code.Break(outer)),
+						code.Case(code.Literal(3)).Then(code.Goto(retry)),
+						code.Case().Then(code.Break(nil)),
+					),
+				),
+			)),
+		)
+	src, err := code.Render(code.File("x", fn))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := `package x
+
+func scan(rows [][]int) {
+retry:
+	_ = 0
+outer:
+	for _, row := range rows {
+		for _, v := range row {
+			switch v {
+			case 0:
+				fallthrough
+			case 1:
+				continue outer
+			case 2:
+				break outer
+			case 3:
+				goto retry
+			default:
+				break
+			}
+		}
+	}
+}
+`
+	if diff := cmp.Diff(expected, string(src)); diff != "" {
+		t.Error("mismatch", diff)
+	}
+
+	loop := code.Label("loop")
+	validateRender(t, "quoted loop", "func f() {\nloop:\n\tfor {\n\t\tswitch {\n\t\tdefault:\n\t\t\tbreak loop\n\t\t}\n\t}\n}",
+		code.Func("f").WithBody(
+			code.Labeled(loop, code.Stmt("for { switch { default: %v } }", code.Break(loop))),
+		))
+
+	invalid := code.Func("f").WithBody(
+		code.Break(nil),
+		code.Labeled(outer, code.Switch(nil, nil).WithBody(
+			code.Case().Then(code.Continue(outer), code.Fallthrough()),
+		)),
+		code.For(nil, nil, nil).WithBody(code.Break(retry)),
+		code.Lambda().WithBody(code.Continue(nil)),
+		code.Labeled(code.Label("unused"), code.Return()),
+		code.Goto(code.Label("missing")),
+		code.Switch(nil, nil).WithBody(
+			code.Case().Then(code.If(code.Ident("x")).Then(code.Fallthrough())),
+			code.Case(),
+		),
+	)
+	_, err = code.Render(code.File("x", invalid))
+	expectedErr := "code: Break: break is not in a loop, switch, or select\n" +
+		"code: Continue: invalid continue label outer\n" +
+		"code: WithBody: cannot fallthrough final case in switch\n" +
+		"code: Break: break label not defined: retry\n" +
+		"code: Continue: continue is not in a loop\n" +
+		"code: WithBody: fallthrough statement out of place\n" +
+		"code: WithBody: label missing not defined\n" +
+		"code: WithBody: label unused defined and not used"
+	if err == nil {
+		t.Fatal("unexpected success")
+	}
+	if diff := cmp.Diff(expectedErr, err.Error()); diff != "" {
+		t.Error("mismatch", diff)
+	}
+}
+
+func TestStruct(t *testing.T) {
+	validate(t, "empty", "type Foo struct {\n}", code.Struct("Foo"))
+	validate(t, "fields", "type Foo struct {\n\tX, Y int\n\tName string `json:\"name\"`\n}",
+		code.Struct("Foo").
+			WithField(code.Ident("X"), code.Ident("Y"), code.Ident("int"), nil).
+			WithField(code.Ident("Name"), code.Ident("string"), code.Literal("`json:\"name\"`")))
+	validate(t, "embed", "type Foo struct {\n\tsync.Mutex\n\t*Bar\n}",
+		code.Struct("Foo").
+			Embed(code.Ident("sync").Dot("Mutex"), code.Ident("Bar").Star()))
+
+	s := code.RootScope()
+	decl := code.Struct("Foo").
+		WithField(code.Ident("X"), code.Ident("int"), nil).
+		WithComment("X is x").
+		MarshalNode(s).(*ast.GenDecl)
+	st := decl.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType)
+	if got := st.Fields.List[0].Comment.Text(); got != "X is x\n" {
+		t.Error("unexpected comment", got)
+	}
+	if _, ok := s.LookupVar("Foo"); !ok {
+		t.Error("struct name not registered")
+	}
+	if _, ok := s.LookupVar("X"); ok {
+		t.Error("field name registered as a variable")
+	}
+}
+
+func TestInterface(t *testing.T) {
+	var buf bytes.Buffer
+	s := code.RootScope()
+	p := code.IdentPrefix("p")
+	iface := code.Interface("Reader").
+		WithMethod(code.Func("Read").
+			WithParam(p, code.Ident("byte").Star(), nil).
+			WithResult(nil, code.Ident("error"), nil)).
+		Embed(code.Ident("io").Dot("Closer"))
+	if err := format.Node(&buf, &token.FileSet{}, iface.MarshalNode(s)); err != nil {
+		t.Fatal("format error", err)
+	}
+
+	expected := "type Reader interface {\n\tRead(p *byte) error\n\tio.Closer\n}"
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Error("mismatch", diff)
+	}
+
+	if name := s.PickName("Reader"); name != "Reader2" {
+		t.Error("interface name not registered", name)
+	}
+	if name := s.PickName("Read"); name != "Read" {
+		t.Error("method name registered", name)
+	}
+}
+
+func TestConstVar(t *testing.T) {
+	x, y := code.Ident("x"), code.Ident("y")
+	validate(t, "const", "const x = 5", code.Const().WithValue(x, nil, code.Literal(5)))
+	validate(t, "typed var", "var x int", code.Var().WithValue(x, code.Ident("int"), nil))
+	validate(t, "grouped", "var (\n\tx = 5\n\ty int\n)",
+		code.Var().
+			WithValue(x, nil, code.Literal(5)).
+			WithValue(y, code.Ident("int"), nil))
+	validate(t, "enum", "const (\n\tx T = iota\n\ty\n)", code.Enum(code.Ident("T"), x, y))
+	validate(t, "decl stmt", "if x {\n\tvar y = x\n}",
+		code.If(x).Then(code.Var().WithValue(y, nil, x)))
+
+	s := code.RootScope()
+	code.Var().WithValue(code.IdentPrefix("v"), nil, code.Literal(5)).MarshalNode(s)
+	if name := s.PickName("v"); name != "v2" {
+		t.Error("var not registered", name)
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	x, y, z := code.Ident("x"), code.Ident("y"), code.Ident("z")
+	validate(t, "for", "for {\n\ty\n}", code.For(nil, nil, nil).WithBody(y))
+	validate(t, "for cond", "for x < y {\n\tz\n}", code.For(nil, x.Op("<", y), nil).WithBody(z))
+	validate(t, "for 3", "for x := 0; x < y; x += 1 {\n\tz\n}",
+		code.For(x.Assign(":=", code.Literal(0)), x.Op("<", y), x.Assign("+=", code.Literal(1))).
+			WithBody(z))
+
+	validate(t, "range", "for range x {\n\ty\n}", code.Range(":=", nil, nil, x).WithBody(y))
+	validate(t, "range key", "for y := range x {\n}", code.Range(":=", y, nil, x).WithBody())
+	validate(t, "range value", "for _, z = range x {\n}", code.Range("=", nil, z, x).WithBody())
+	validate(t, "range kv", "for y, z := range x {\n}", code.Range(":=", y, z, x).WithBody())
+
+	validate(t, "switch", "switch x {\ncase 1, 2:\n\ty\ndefault:\n\tz\n}",
+		code.Switch(nil, x).WithBody(
+			code.Case(code.Literal(1), code.Literal(2)).Then(y),
+			code.Default().Then(z),
+		))
+	validate(t, "switch init", "switch y := x; {\ncase y:\n}",
+		code.Switch(y.Assign(":=", x), nil).WithBody(code.Case(y)))
+
+	validate(t, "type switch", "switch x.(type) {\ncase int:\n\ty\n}",
+		code.TypeSwitch(nil, x).WithBody(code.Case(code.Ident("int")).Then(y)))
+	v := code.IdentPrefix("x")
+	validate(t, "type switch bind", "func f(x int) {\n\tswitch x2 := x.(type) {\n\tcase int:\n\t\tx2\n\t}\n}",
+		code.Func("f").WithParam(x, code.Ident("int"), nil).WithBody(
+			code.TypeSwitch(v, x).WithBody(code.Case(code.Ident("int")).Then(v)),
+		))
+
+	validate(t, "select", "select {\ncase y := <-x:\n\ty\ncase x <- z:\ndefault:\n\tz\n}",
+		code.Select().WithBody(
+			code.Comm(y.Assign(":=", x.Op("<-", nil))).Then(y),
+			code.Comm(code.MarshalerFunc(func(s *code.Scope) ast.Node {
+				return &ast.SendStmt{Chan: ast.NewIdent("x"), Value: ast.NewIdent("z")}
+			})),
+			code.Default().Then(z),
+		))
+}
+
+func TestTypes(t *testing.T) {
+	str, num := code.Ident("string"), code.Ident("int")
+	validate(t, "slice", "[]string", code.SliceOf(str))
+	validate(t, "array", "[5]string", code.ArrayOf(code.Literal(5), str))
+	validate(t, "array ellipsis", "[...]int{1, 2}",
+		code.Composite(code.ArrayOf(nil, num), code.Literal(1), code.Literal(2)))
+	validate(t, "slice literal", "[]int{1, 2}",
+		code.Composite(code.SliceOf(num), code.Literal(1), code.Literal(2)))
+	validate(t, "map", "map[string]int{\"a\": 1}",
+		code.Composite(code.MapOf(str, num), code.KeyValue(code.Literal(`"a"`), code.Literal(1))))
+	validate(t, "chan", "chan int", code.ChanOf(ast.SEND|ast.RECV, num))
+	validate(t, "send chan", "chan<- error", code.ChanOf(ast.SEND, code.Ident("error")))
+	validate(t, "recv chan", "<-chan error", code.ChanOf(ast.RECV, code.Ident("error")))
+	validate(t, "func", "func(int) error",
+		code.FuncType().WithParam(nil, num, nil).WithResult(nil, code.Ident("error"), nil))
+	validate(t, "nested", "package x\n\nimport \"net/http\"\n\nvar x map[string][]*http.Request\n",
+		code.File("x", code.Var().WithValue(code.Ident("x"),
+			code.MapOf(str, code.SliceOf(code.Import("net/http").Dot("Request").Star())), nil)))
+	validate(t, "variadic", "func f(x ...string) {\n}",
+		code.Func("f").WithParam(code.Ident("x"), code.Ellipsis(str), nil).WithBody())
+}
+
+func TestGenerics(t *testing.T) {
+	k, v := code.Ident("K"), code.Ident("V")
+	validate(t, "generic struct", "type Pair[K comparable, V any] struct {\n\tKey K\n}",
+		code.Struct("Pair").
+			WithTypeParam(k, code.Ident("comparable")).
+			WithTypeParam(v, code.Ident("any")).
+			WithField(code.Ident("Key"), k, nil))
+	validate(t, "union", "type Number interface {\n\t~int | float64\n}",
+		code.Interface("Number").Embed(code.Union(code.Tilde(code.Ident("int")), code.Ident("float64"))))
+	validate(t, "generic func", "func Keys[K, V any](m map[K]V) {\n}",
+		code.Func("Keys").
+			WithTypeParam(k, v, code.Ident("any")).
+			WithParam(code.Ident("m"), code.MapOf(k, v), nil).
+			WithBody())
+	validate(t, "index", "x[1]", code.Ident("x").Index(code.Literal(1)))
+	validate(t, "instantiate", "Map[K, V]", code.Ident("Map").Index(k, v))
+
+	_, err := code.MarshalNodeErr(code.Const().WithTypeParam(k, code.Ident("any")), code.RootScope())
+	if err == nil || err.Error() != "code: WithTypeParam: expected a type declaration but Const produced *ast.GenDecl (const ())" {
+		t.Error("unexpected error", err)
+	}
+}
+
+// validate formats the node marshaled in a fresh scope and compares
+// it against the expected source
+func validate(t *testing.T, test, expected string, m code.NodeMarshaler) {
+	t.Helper()
+	var buf bytes.Buffer
+	result := m.MarshalNode(code.RootScope())
+	if err := format.Node(&buf, &token.FileSet{}, result); err != nil {
+		t.Fatal(test, "format error", err)
+	}
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Error(test, "mismatch", diff)
+	}
+}
+
+// validateRender renders the marshaler and compares it against the
+// expected source
+func validateRender(t *testing.T, test, expected string, m code.NodeMarshaler) {
+	t.Helper()
+	src, err := code.Render(m)
+	if err != nil {
+		t.Fatal(test, "unexpected error", err)
+	}
+	if diff := cmp.Diff(expected, string(src)); diff != "" {
+		t.Error(test, "mismatch", diff)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// Struct creates a struct type declaration.
+//
+// Fields are added using WithField and Embed:
+//
+//	code.Struct("Point").
+//	    WithField(code.Ident("X"), code.Ident("int"), nil).
+//	    WithComment("X is the horizontal offset").
+//	    Embed(code.Import("sync").Dot("Mutex"))
+func Struct(name string) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		s.Vars[name] = ast.NewIdent(name)
+		decl := typeDecl(name, &ast.StructType{Fields: &ast.FieldList{}})
+		s.declareType(decl.Specs[0].(*ast.TypeSpec))
+		return decl
+	})
+}
+
+// Interface creates an interface type declaration.
+//
+// Methods are added using WithMethod with the signature built using
+// Func and embedded interfaces are added using Embed:
+//
+//	code.Interface("ReadCloser").
+//	    WithMethod(code.Func("Read").
+//	        WithParam(code.Ident("p"), code.SliceOf(code.Ident("byte")), nil).
+//	        WithResult(code.Ident("n"), code.Ident("int"), nil).
+//	        WithResult(code.Ident("err"), code.Ident("error"), nil)).
+//	    Embed(code.Import("io").Dot("Closer"))
+func Interface(name string) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		s.Vars[name] = ast.NewIdent(name)
+		decl := typeDecl(name, &ast.InterfaceType{Methods: &ast.FieldList{}})
+		s.declareType(decl.Specs[0].(*ast.TypeSpec))
+		return decl
+	})
+}
+
+func (n nodef) WithMethod(fn NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		decl := n.MarshalNode(s)
+		methods := fieldList(s, decl)
+		// params and method names are not variables of this scope
+		result := fn.MarshalNode(s.New())
+		m, ok := result.(*ast.FuncDecl)
+		if !ok {
+			s.unexpected(fn, result, "a func")
+			return decl
+		}
+		methods.List = append(methods.List, &ast.Field{
+			Names: []*ast.Ident{m.Name},
+			Type:  m.Type,
+		})
+		return decl
+	})
+}
+
+func (n nodef) WithField(args ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		decl := n.MarshalNode(s)
+		fields := fieldList(s, decl)
+		// field names are not variables, so use a throwaway scope
+		fields.List = append(fields.List, field(s.New(), args...))
+		return decl
+	})
+}
+
+func (n nodef) Embed(types ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		decl := n.MarshalNode(s)
+		fields := fieldList(s, decl)
+		for _, t := range types {
+			x := expr(s, t)
+			fields.List = append(fields.List, &ast.Field{Type: x})
+		}
+		return decl
+	})
+}
+
+func (n nodef) WithComment(text string) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		decl := n.MarshalNode(s)
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok != token.TYPE {
+			if len(gen.Specs) == 0 {
+				s.Errorf("comment without a value")
+			} else if spec, ok := gen.Specs[len(gen.Specs)-1].(*ast.ValueSpec); ok {
+				spec.Comment = comments(text)
+			}
+			return decl
+		}
+
+		fields := fieldList(s, decl)
+		if len(fields.List) == 0 {
+			s.Errorf("comment without a field")
+		} else {
+			fields.List[len(fields.List)-1].Comment = comments(text)
+		}
+		return decl
+	})
+}
+
+// Const creates an empty const declaration.
+//
+// Use WithValue to add constants to it:
+//
+//	code.Const().
+//	    WithValue(code.Ident("x"), nil, code.Literal(5)).
+//	    WithValue(code.Ident("y"), code.Ident("float64"), code.Literal(1.5))
+//
+// When used as a statement, the declaration is converted into an
+// ast.DeclStmt.
+func Const() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.GenDecl{Tok: token.CONST}
+	})
+}
+
+// Var creates an empty var declaration.
+//
+// Use WithValue to add variables to it. When used as a statement,
+// the declaration is converted into an ast.DeclStmt.
+func Var() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.GenDecl{Tok: token.VAR}
+	})
+}
+
+// Iota is the iota identifier
+func Iota() NodeMarshaler {
+	return Ident("iota")
+}
+
+// Enum creates a const declaration of the provided type with one
+// constant per name, numbered using iota:
+//
+//	const (
+//	    A T = iota
+//	    B
+//	)
+func Enum(typ NodeMarshaler, names ...NodeMarshaler) NodeMarshaler {
+	result := Const()
+	for kk, name := range names {
+		if kk == 0 {
+			result = result.WithValue(name, typ, Iota())
+		} else {
+			result = result.WithValue(name, nil, nil)
+		}
+	}
+	return result
+}
+
+func (n nodef) WithValue(args ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		result := n.MarshalNode(s)
+		decl, ok := result.(*ast.GenDecl)
+		if !ok {
+			s.unexpected(n, result, "a const or var declaration")
+			return result
+		}
+		decl.Specs = append(decl.Specs, valueSpec(s, args...))
+		return decl
+	})
+}
+
+// valueSpec is like field except that the last arg is the value and
+// the one before that is the type
+func valueSpec(s *Scope, args ...NodeMarshaler) *ast.ValueSpec {
+	spec := &ast.ValueSpec{}
+	for kk, arg := range args {
+		if arg == nil {
+			continue
+		}
+		n := arg.MarshalNode(s)
+		if n == nil {
+			continue
+		}
+
+		switch kk {
+		case len(args) - 1:
+			spec.Values = []ast.Expr{toExpr(s, arg, n)}
+		case len(args) - 2:
+			spec.Type = toExpr(s, arg, n)
+		default:
+			nn := toIdent(s, arg, n)
+			s.Vars[nn.Name] = nn
+			spec.Names = append(spec.Names, nn)
+		}
+	}
+	for _, name := range spec.Names {
+		var value ast.Expr
+		if len(spec.Names) == len(spec.Values) {
+			value = spec.Values[0]
+		}
+		s.declareVar(name.Name, spec.Type, value)
+	}
+	return spec
+}
+
+func typeDecl(name string, t ast.Expr) *ast.GenDecl {
+	return &ast.GenDecl{
+		Tok:   token.TYPE,
+		Specs: []ast.Spec{&ast.TypeSpec{Name: ast.NewIdent(name), Type: t}},
+	}
+}
+
+// fieldList returns the fields of a struct declaration or the
+// methods of an interface declaration
+func fieldList(s *Scope, n ast.Node) *ast.FieldList {
+	switch x := n.(type) {
+	case *ast.GenDecl:
+		if len(x.Specs) > 0 {
+			return fieldList(s, x.Specs[len(x.Specs)-1])
+		}
+	case *ast.TypeSpec:
+		return fieldList(s, x.Type)
+	case *ast.StructType:
+		return x.Fields
+	case *ast.InterfaceType:
+		return x.Methods
+	}
+	s.Errorf("expected a struct or interface but got %T", n)
+	return &ast.FieldList{}
+}
+
+// comments converts text into a comment group, one line comment per
+// line of text
+func comments(text string) *ast.CommentGroup {
+	g := &ast.CommentGroup{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight("// "+line, " ")
+		g.List = append(g.List, &ast.Comment{Text: line})
+	}
+	return g
+}
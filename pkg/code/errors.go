@@ -0,0 +1,162 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"fmt"
+	"go/ast"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// MarshalNodeErr marshals the node returning all the errors reported
+// while doing so.
+//
+// Builders do not panic when they are combined incorrectly (such as
+// using a statement where an expression is expected). They report
+// the error with Scope.Errorf and continue with a placeholder so that
+// all the mistakes are reported together:
+//
+//	code: Op: expected an expression but If produced *ast.IfStmt (if x {)
+//
+// Panics are also converted into errors.
+func MarshalNodeErr(m NodeMarshaler, s *Scope) (node ast.Node, err error) {
+	errs := s.errors()
+	start := len(*errs)
+	defer func() {
+		if r := recover(); r != nil {
+			*errs = append(*errs, fmt.Errorf("code: %s: %v", currentBuilder(), r))
+		}
+		if len(*errs) > start {
+			node, err = nil, append(Errors(nil), (*errs)[start:]...)
+		}
+	}()
+	return m.MarshalNode(s), nil
+}
+
+// Errorf reports an error while marshaling. The name of the builder
+// being marshaled is added to the message.
+//
+// Custom marshalers can use it instead of panicking.  The errors are
+// returned by MarshalNodeErr and Render.
+func (s *Scope) Errorf(format string, args ...interface{}) {
+	errs := s.errors()
+	msg := fmt.Sprintf(format, args...)
+	*errs = append(*errs, fmt.Errorf("code: %s: %s", currentBuilder(), msg))
+}
+
+// Err returns the errors reported so far or nil
+func (s *Scope) Err() error {
+	if errs := *s.errors(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Errors is a list of errors reported together
+type Errors []error
+
+// Error returns all the errors, one per line
+func (e Errors) Error() string {
+	result := make([]string, len(e))
+	for kk, err := range e {
+		result[kk] = err.Error()
+	}
+	return strings.Join(result, "\n")
+}
+
+// errors returns the errors stashed in the root scope
+func (s *Scope) errors() *Errors {
+	root := s.root()
+	if errs, ok := root.Stash[&errorsKey]; ok {
+		return errs.(*Errors)
+	}
+	errs := &Errors{}
+	root.Stash[&errorsKey] = errs
+	return errs
+}
+
+var errorsKey = "errors"
+
+// expr marshals the node which is expected to be an expression
+func expr(s *Scope, m NodeMarshaler) ast.Expr {
+	return toExpr(s, m, m.MarshalNode(s))
+}
+
+// toExpr converts the node marshaled by m to an expression
+func toExpr(s *Scope, m NodeMarshaler, n ast.Node) ast.Expr {
+	if x, ok := n.(ast.Expr); ok {
+		return x
+	}
+	s.unexpected(m, n, "an expression")
+	return &ast.BadExpr{}
+}
+
+// ident marshals the node which is expected to be an identifier
+func ident(s *Scope, m NodeMarshaler) *ast.Ident {
+	return toIdent(s, m, m.MarshalNode(s))
+}
+
+// toIdent converts the node marshaled by m to an identifier
+func toIdent(s *Scope, m NodeMarshaler, n ast.Node) *ast.Ident {
+	if x, ok := n.(*ast.Ident); ok {
+		return x
+	}
+	s.unexpected(m, n, "an identifier")
+	return ast.NewIdent("_")
+}
+
+// unexpected reports that the marshaler produced the wrong kind of
+// node
+func (s *Scope) unexpected(m NodeMarshaler, n ast.Node, want string) {
+	got := fmt.Sprintf("%T", n)
+	if n == nil {
+		got = "nil"
+	} else if text := summary(n); text != got {
+		got += " (" + text + ")"
+	}
+	s.Errorf("expected %s but %s produced %s", want, marshalerName(m), got)
+}
+
+// marshalerName returns the builder name of the marshaler
+func marshalerName(m NodeMarshaler) string {
+	if n, ok := m.(nodef); ok {
+		return builderName(runtime.FuncForPC(reflect.ValueOf(n).Pointer()).Name())
+	}
+	return fmt.Sprintf("%T", m)
+}
+
+// currentBuilder returns the name of the innermost builder being
+// marshaled by looking for marshaling funcs of this package on the
+// call stack.
+func currentBuilder() string {
+	pcs := make([]uintptr, 100)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		name := builderName(frame.Function)
+		if name != frame.Function && closureSuffix.MatchString(frame.Function) {
+			return name
+		}
+		if !more {
+			return "unknown builder"
+		}
+	}
+}
+
+// builderName strips the package and closure suffix of the function
+// name of a builder
+func builderName(name string) string {
+	pkg := reflect.TypeOf(nodef(nil)).PkgPath() + "."
+	if short := strings.TrimPrefix(name, pkg); short != name {
+		short = closureSuffix.ReplaceAllString(short, "")
+		return strings.TrimPrefix(short, "nodef.")
+	}
+	return name
+}
+
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
@@ -7,10 +7,7 @@ package code_test
 import (
 	"github.com/tvastar/gogo/pkg/code"
 
-	"bytes"
 	"fmt"
-	"go/format"
-	"go/token"
 )
 
 func Example() {
@@ -29,20 +26,19 @@ func Example() {
 			WithBody(code.If2(n.Assign(":=", x), n.Op("<", y)).Then(strz)),
 	)
 
-	var buf bytes.Buffer
-	node := file.MarshalNode(code.RootScope())
-	if err := format.Node(&buf, &token.FileSet{}, node); err != nil {
+	src, err := code.Render(file)
+	if err != nil {
 		fmt.Println("Unexpected error", err)
 	}
 
-	fmt.Println(buf.String())
+	fmt.Println(string(src))
 
 	// Output:
 	// package example
 	//
 	// import "strconv"
 	//
-	// func () testfn(x int, y int) (string) {
+	// func testfn(x int, y int) string {
 	// 	if n := x; n < y {
 	// 		return strconv.Itoa(z)
 	// 	}
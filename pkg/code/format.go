@@ -0,0 +1,270 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Comment is a comment line that can be used as a statement.  It
+// cannot be used where an expression is expected.
+//
+// Use WithDoc and WithComment to attach comments to declarations and
+// fields instead.
+func Comment(text string) NodeMarshaler {
+	value := commentText(comments(text))
+	return nodef(func(*Scope) ast.Node {
+		return &ast.ExprStmt{X: &ast.BasicLit{Kind: token.COMMENT, Value: value}}
+	})
+}
+
+// Header is a comment placed at the top of the file, before the
+// package clause and separated from it. It is meant to be used
+// within File:
+//
+//	code.File("example", code.Header("Copyright ..."), ...)
+func Header(text string) NodeMarshaler {
+	return nodef(func(*Scope) ast.Node {
+		return comments(text)
+	})
+}
+
+// Generated is the standard "Code generated ... DO NOT EDIT." header
+func Generated(tool string) NodeMarshaler {
+	return Header("Code generated by " + tool + ". DO NOT EDIT.")
+}
+
+func (n nodef) WithDoc(text string) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		result := n.MarshalNode(s)
+		switch x := result.(type) {
+		case *ast.File:
+			x.Doc = comments(text)
+		case *ast.FuncDecl:
+			x.Doc = comments(text)
+		case *ast.GenDecl:
+			x.Doc = comments(text)
+		default:
+			s.unexpected(n, result, "a file or declaration")
+		}
+		return result
+	})
+}
+
+// Format formats the node as Go source, similar to format.Node.
+//
+// Unlike format.Node, all comments attached using Header, WithDoc,
+// WithComment and Comment are preserved. The generated nodes do not
+// have any position information which format.Node needs to place
+// comments.
+//
+// The standard library imports are separated from the others.  Use
+// Render to also separate the local imports.
+func Format(node ast.Node) ([]byte, error) {
+	return formatFile(node, "")
+}
+
+// formatFile formats the node, using the local prefix to separate the
+// import groups
+func formatFile(node ast.Node, local string) ([]byte, error) {
+	if decl, ok := node.(ast.Decl); ok {
+		// format within a file so that the comments are placed
+		f := &ast.File{Name: ast.NewIdent("p"), Decls: []ast.Decl{decl}}
+		src, err := formatFile(f, local)
+		if err != nil {
+			return nil, err
+		}
+		src = bytes.TrimPrefix(src, []byte("package p\n\n"))
+		return bytes.TrimSuffix(src, []byte("\n")), nil
+	}
+
+	f, ok := node.(*ast.File)
+	if !ok {
+		var buf bytes.Buffer
+		err := format.Node(&buf, token.NewFileSet(), node)
+		return buf.Bytes(), err
+	}
+
+	// print without any comments other than the Comment statements
+	var buf bytes.Buffer
+	bare := *f
+	bare.Doc = nil
+	bare.Comments = []*ast.CommentGroup{}
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := cfg.Fprint(&buf, token.NewFileSet(), &bare); err != nil {
+		return nil, err
+	}
+
+	// reparse to find out where each node ended up
+	fset := token.NewFileSet()
+	parsed, err := parser.ParseFile(fset, "", buf.Bytes(), 0)
+	if err != nil {
+		return nil, sourceError(err, buf.String())
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	before := map[int][]string{}
+	after := map[int][]string{}
+	lineOf := func(pos token.Pos) int {
+		return fset.Position(pos).Line - 1
+	}
+	indentOf := func(line int) string {
+		text := lines[line]
+		return text[:len(text)-len(strings.TrimLeft(text, "\t"))]
+	}
+	addDoc := func(g *ast.CommentGroup, pos token.Pos) {
+		if g != nil {
+			line := lineOf(pos)
+			for _, c := range g.List {
+				before[line] = append(before[line], indentOf(line)+c.Text)
+			}
+		}
+	}
+	addComment := func(g *ast.CommentGroup, end token.Pos) {
+		if g != nil {
+			line := lineOf(end - 1)
+			for kk, c := range g.List {
+				text := c.Text
+				if kk > 0 {
+					text = "\n" + indentOf(line) + text
+				}
+				after[line] = append(after[line], text)
+			}
+		}
+	}
+
+	addDoc(f.Doc, parsed.Package)
+	for _, decl := range parsed.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			lastGroup := -1
+			for _, spec := range gen.Specs {
+				path, _ := strconv.Unquote(spec.(*ast.ImportSpec).Path.Value)
+				group := importGroup(path, local)
+				if lastGroup >= 0 && group != lastGroup {
+					line := lineOf(spec.Pos())
+					before[line] = append(before[line], "")
+				}
+				lastGroup = group
+			}
+		}
+	}
+	err = walkPairs(f, parsed, func(n1, n2 ast.Node) {
+		switch n1 := n1.(type) {
+		case *ast.FuncDecl:
+			addDoc(n1.Doc, n2.Pos())
+		case *ast.GenDecl:
+			addDoc(n1.Doc, n2.Pos())
+		case *ast.Field:
+			addDoc(n1.Doc, n2.Pos())
+			addComment(n1.Comment, n2.End())
+		case *ast.ValueSpec:
+			addDoc(n1.Doc, n2.Pos())
+			addComment(n1.Comment, n2.End())
+		case *ast.TypeSpec:
+			addDoc(n1.Doc, n2.Pos())
+			addComment(n1.Comment, n2.End())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	for _, g := range f.Comments {
+		out.WriteString(commentText(g) + "\n\n")
+	}
+	for kk, line := range lines {
+		for _, doc := range before[kk] {
+			out.WriteString(doc + "\n")
+		}
+		out.WriteString(line)
+		for _, comment := range after[kk] {
+			out.WriteString(" " + comment)
+		}
+		if kk < len(lines)-1 {
+			out.WriteString("\n")
+		}
+	}
+	return format.Source(out.Bytes())
+}
+
+// walkPairs walks the generated node and its reparsed version in
+// lock step.  Comment statements are skipped as they are not part
+// of the reparsed version and so are empty field lists as the parser
+// leaves out empty results.
+//
+// It fails if the two versions do not match.
+func walkPairs(generated, parsed ast.Node, fn func(n1, n2 ast.Node)) error {
+	collect := func(root ast.Node) []ast.Node {
+		var result []ast.Node
+		ast.Inspect(root, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case nil, *ast.CommentGroup, *ast.Comment:
+				return false
+			case *ast.ExprStmt:
+				if isComment(n.X) {
+					return false
+				}
+			case *ast.FieldList:
+				if len(n.List) == 0 {
+					return false
+				}
+			}
+			result = append(result, n)
+			return true
+		})
+		return result
+	}
+
+	l1, l2 := collect(generated), collect(parsed)
+	for kk := 0; kk < len(l1) && kk < len(l2); kk++ {
+		if reflect.TypeOf(l1[kk]) != reflect.TypeOf(l2[kk]) {
+			return fmt.Errorf("code: generated %T (%s) was formatted as %T", l1[kk], summary(l1[kk]), l2[kk])
+		}
+		fn(l1[kk], l2[kk])
+	}
+	if len(l1) != len(l2) {
+		return fmt.Errorf("code: generated %d nodes but %d were formatted", len(l1), len(l2))
+	}
+	return nil
+}
+
+// sourceError annotates a parse error of the generated source with
+// the offending line
+func sourceError(err error, src string) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok || len(list) == 0 {
+		return err
+	}
+	lines := strings.Split(src, "\n")
+	line := list[0].Pos.Line
+	if line < 1 || line > len(lines) {
+		return err
+	}
+	return fmt.Errorf("code: invalid code generated: %v: %s", list[0], strings.TrimSpace(lines[line-1]))
+}
+
+func isComment(x ast.Expr) bool {
+	lit, ok := x.(*ast.BasicLit)
+	return ok && lit.Kind == token.COMMENT
+}
+
+func commentText(g *ast.CommentGroup) string {
+	result := make([]string, len(g.List))
+	for kk, c := range g.List {
+		result[kk] = c.Text
+	}
+	return strings.Join(result, "\n")
+}
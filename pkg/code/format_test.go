@@ -0,0 +1,100 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/tvastar/gogo/pkg/code"
+
+	"strings"
+	"testing"
+)
+
+func TestFormatComments(t *testing.T) {
+	x := code.Ident("x")
+	file := code.File(
+		"example",
+		code.Generated("gogo"),
+		code.Struct("Point").
+			WithDoc("Point is a point").
+			WithField(code.Ident("X"), code.Ident("int"), nil).
+			WithComment("X is the x coord").
+			WithField(code.Ident("Y"), code.Ident("int"), nil).
+			WithComment("Y is the y coord\nwith a second line"),
+		code.Enum(code.Ident("Color"), code.Ident("Red"), code.Ident("Green")).
+			WithComment("Green is green").
+			WithDoc("Colors"),
+		code.Func("Fn").
+			WithDoc("Fn does things.\n\nIt has multiple paragraphs.").
+			WithParam(x, code.Ident("int"), nil).
+			WithBody(
+				code.Comment("check x"),
+				code.If(x).Then(code.Comment("nothing to do")),
+			),
+	).WithDoc("Package example is an example")
+
+	result, err := code.Format(file.MarshalNode(code.RootScope()))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := `// Code generated by gogo. DO NOT EDIT.
+
+// Package example is an example
+package example
+
+// Point is a point
+type Point struct {
+	X int // X is the x coord
+	Y int // Y is the y coord
+	// with a second line
+}
+
+// Colors
+const (
+	Red   Color = iota
+	Green       // Green is green
+)
+
+// Fn does things.
+//
+// It has multiple paragraphs.
+func Fn(x int) {
+	// check x
+	if x {
+		// nothing to do
+	}
+}
+`
+	if diff := cmp.Diff(expected, string(result)); diff != "" {
+		t.Error("mismatch", diff)
+	}
+}
+
+func TestFormatDocs(t *testing.T) {
+	file := code.File("p",
+		code.Func("f").WithDoc("f doc").WithBody(),
+		code.Func("g").WithDoc("g doc").WithBody(),
+		code.Struct("T").WithField(code.Ident("X"), code.Ident("int"), nil).WithComment("X doc"),
+	)
+	expected := "package p\n\n// f doc\nfunc f() {\n}\n\n// g doc\nfunc g() {\n}\n\ntype T struct {\n\tX int // X doc\n}\n"
+	validateRender(t, "file", expected, file)
+
+	validateRender(t, "decl", "// f does\nfunc f() {\n}", code.Func("f").WithDoc("f does").WithBody())
+
+	_, err := code.Render(code.Ident("g").Call(code.Comment("x")))
+	if err == nil || !strings.Contains(err.Error(), "expected an expression but Comment produced *ast.ExprStmt") {
+		t.Error("unexpected error", err)
+	}
+
+	invalid := code.File("x",
+		code.Func("f").WithBody(),
+		code.Var().WithValue(code.Ident("a"), nil, code.Ident("y")),
+	)
+	err = code.Check(invalid, nil)
+	if err == nil || err.Error() != "generated.go:6:9: undefined: y (Ident: y)" {
+		t.Error("unexpected error", err)
+	}
+}
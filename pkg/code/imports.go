@@ -0,0 +1,140 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"go/ast"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LocalImports marshals the node treating imports with the provided
+// prefix as local to the module.  The prefix is a path: with
+// "example.com/me", "example.com/me/x" is local but
+// "example.com/meta" is not.
+//
+// File groups imports the same way goimports does: standard library
+// imports first, then third-party imports and then the local
+// imports, with each group sorted.
+func LocalImports(prefix string, n NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		s = s.New()
+		s.Stash[&localKey] = prefix
+		return n.MarshalNode(s)
+	})
+}
+
+var localKey = "local"
+
+// setLocalPrefix records the local prefix used for the file so that
+// the import groups can be separated when formatting
+func setLocalPrefix(s *Scope, f *ast.File, prefix string) {
+	root := s.root()
+	prefixes, ok := root.Stash[&prefixesKey].(map[*ast.File]string)
+	if !ok {
+		prefixes = map[*ast.File]string{}
+		root.Stash[&prefixesKey] = prefixes
+	}
+	prefixes[f] = prefix
+}
+
+func localPrefix(s *Scope, node ast.Node) string {
+	prefixes, _ := s.root().Stash[&prefixesKey].(map[*ast.File]string)
+	f, _ := node.(*ast.File)
+	return prefixes[f]
+}
+
+var prefixesKey = "prefixes"
+
+// importGroup returns the goimports group of the import path
+func importGroup(path, local string) int {
+	switch {
+	case local != "" && isLocal(path, strings.TrimSuffix(local, "/")):
+		return 2
+	case strings.Contains(strings.SplitN(path, "/", 2)[0], "."):
+		return 1
+	}
+	return 0
+}
+
+// isLocal checks if path is the local path or within it
+func isLocal(path, local string) bool {
+	return path == local || strings.HasPrefix(path, local+"/")
+}
+
+// cleanImports removes unused imports and sorts the rest into
+// groups.  Blank and dot imports are always retained.
+func cleanImports(f *ast.File, imports *ast.GenDecl, local string) {
+	used := map[string]bool{"_": true, ".": true}
+	for _, decl := range f.Decls {
+		if decl == imports {
+			continue
+		}
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					used[x.Name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	specs := imports.Specs[:0]
+	for _, spec := range imports.Specs {
+		if used[importName(f, spec.(*ast.ImportSpec))] {
+			specs = append(specs, spec)
+		}
+	}
+	imports.Specs = specs
+
+	fileSpecs := f.Imports[:0]
+	for _, spec := range f.Imports {
+		if used[spec.Name.Name] {
+			fileSpecs = append(fileSpecs, spec)
+		}
+	}
+	f.Imports = fileSpecs
+
+	sortImports(imports.Specs, local)
+}
+
+// importName returns the name the import is referred to by
+func importName(f *ast.File, spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	for _, other := range f.Imports {
+		if other.Path.Value == spec.Path.Value && other.Name.Name != "_" && other.Name.Name != "." {
+			return other.Name.Name
+		}
+	}
+	return ""
+}
+
+func sortImports(specs []ast.Spec, local string) {
+	key := func(idx int) (int, string, string) {
+		spec := specs[idx].(*ast.ImportSpec)
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := ""
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		return importGroup(path, local), path, name
+	}
+
+	sort.SliceStable(specs, func(i, j int) bool {
+		gi, pi, ni := key(i)
+		gj, pj, nj := key(j)
+		if gi != gj {
+			return gi < gj
+		}
+		if pi != pj {
+			return pi < pj
+		}
+		return ni < nj
+	})
+}
@@ -0,0 +1,322 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"go/ast"
+	"go/token"
+	"sort"
+)
+
+// Label is an unique label of the enclosing function using the
+// provided prefix.  Like IdentPrefix, the name is picked on first
+// use within the function and reused after that:
+//
+//	outer := code.Label("outer")
+//	code.Labeled(outer, code.For(nil, nil, nil).WithBody(
+//	    code.Range(":=", nil, v, items).WithBody(
+//	        code.If(v).Then(code.Break(outer)),
+//	    ),
+//	))
+//
+// Labels do not collide with variables.
+func Label(prefix string) NodeMarshaler {
+	key := &struct{ prefix string }{prefix}
+	return nodef(func(s *Scope) ast.Node {
+		l := funcLabels(s)
+		if id, ok := l.Stash[key]; ok {
+			return id.(*ast.Ident)
+		}
+		id := ast.NewIdent(l.PickName(prefix))
+		l.Vars[id.Name] = id
+		l.Stash[key] = id
+		return id
+	})
+}
+
+// Labeled represents a labeled statement. Break and Continue can
+// refer to the label if the statement is a loop, switch or select.
+func Labeled(label, stmt NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		id := ident(s, label)
+		l := funcLabels(s)
+		if l.defined[id.Name] {
+			s.Errorf("label %s already defined", id.Name)
+		}
+		l.defined[id.Name] = true
+
+		s = s.New()
+		s.Stash[&labelKey] = id.Name
+		return &ast.LabeledStmt{Label: id, Stmt: toStmt(s, stmt, stmt.MarshalNode(s))}
+	})
+}
+
+// Break represents a break statement. The label can be nil.
+//
+// It is an error to use it outside of a loop, switch or select or
+// with a label that is not of an enclosing one.  The statements
+// created with Stmt count too when Break is one of the args.
+func Break(label NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return branch(s, token.BREAK, label, func(t *target) bool {
+			return t.kind != "func"
+		})
+	})
+}
+
+// Continue represents a continue statement. The label can be nil.
+//
+// It is an error to use it outside of a loop or with a label that is
+// not of an enclosing loop.
+func Continue(label NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return branch(s, token.CONTINUE, label, func(t *target) bool {
+			return t.kind == "for"
+		})
+	})
+}
+
+// Goto represents a goto statement. The label must be defined
+// somewhere in the enclosing function.
+func Goto(label NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		id := ident(s, label)
+		l := funcLabels(s)
+		l.used[id.Name] = true
+		l.gotos = append(l.gotos, id.Name)
+		return &ast.BranchStmt{Tok: token.GOTO, Label: id}
+	})
+}
+
+// Fallthrough represents a fallthrough statement.  It must be the
+// last statement directly within a case clause that is not the last
+// clause of a switch statement.
+func Fallthrough() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		result := &ast.BranchStmt{Tok: token.FALLTHROUGH}
+		// the placement is checked once the switch body is done
+		t := enclosing(s)
+		switch {
+		case t != nil && t.kind == "switch":
+			t.fallthroughs = append(t.fallthroughs, result)
+		case t != nil && t.kind == "type switch":
+			s.Errorf("cannot fallthrough in type switch")
+		default:
+			s.Errorf("fallthrough statement out of place")
+		}
+		return result
+	})
+}
+
+// branch validates the break or continue against the enclosing
+// targets
+func branch(s *Scope, tok token.Token, label NodeMarshaler, valid func(t *target) bool) *ast.BranchStmt {
+	result := &ast.BranchStmt{Tok: tok}
+	if label != nil {
+		result.Label = ident(s, label)
+		funcLabels(s).used[result.Label.Name] = true
+	}
+
+	for t := enclosing(s); t != nil && t.kind != "func"; t = t.parent {
+		if label == nil && valid(t) || label != nil && t.label == result.Label.Name {
+			if !valid(t) {
+				s.Errorf("invalid %s label %s", tok, t.label)
+			}
+			return result
+		}
+	}
+
+	switch {
+	case label != nil:
+		s.Errorf("%s label not defined: %s", tok, result.Label.Name)
+	case tok == token.CONTINUE:
+		s.Errorf("continue is not in a loop")
+	default:
+		s.Errorf("break is not in a loop, switch, or select")
+	}
+	return result
+}
+
+// target is a statement that can be the target of break or
+// continue. Func boundaries are also tracked as targets.
+type target struct {
+	kind, label  string
+	parent       *target
+	fallthroughs []*ast.BranchStmt
+}
+
+// labels tracks the labels of a function
+type labels struct {
+	*Scope
+	defined, used map[string]bool
+	gotos         []string
+}
+
+var labelKey = "label"
+
+var targetKey = "target"
+
+var labelsKey = "labels"
+
+// enclosing returns the innermost target
+func enclosing(s *Scope) *target {
+	t, _ := s.LookupStash(&targetKey)
+	result, _ := t.(*target)
+	return result
+}
+
+// funcLabels returns the labels of the enclosing function. Outside
+// of a function, the labels are tracked in the root scope.
+func funcLabels(s *Scope) *labels {
+	if l, ok := s.LookupStash(&labelsKey); ok {
+		return l.(*labels)
+	}
+	l := newLabels()
+	s.root().Stash[&labelsKey] = l
+	return l
+}
+
+func newLabels() *labels {
+	return &labels{Scope: RootScope(), defined: map[string]bool{}, used: map[string]bool{}}
+}
+
+// bodyScope creates the scope for the body of the node.  Loops,
+// switch and select statements are targets for break and continue
+// and funcs get their own labels.
+//
+// The body of a func uses the scope of the func itself as the
+// params, receiver and named results are in the same block as the
+// top-level statements.
+func bodyScope(s *Scope, n ast.Node) *Scope {
+	kind := targetKind(n)
+	switch kind {
+	case "":
+		return s.New()
+	case "func":
+		s.Stash[&targetKey] = &target{kind: kind, parent: enclosing(s)}
+		s.Stash[&labelsKey] = newLabels()
+		return s
+	}
+	// s is the scope of the WithBody call directly within Labeled
+	name := ""
+	if s.Parent != nil {
+		name, _ = s.Parent.Stash[&labelKey].(string)
+	}
+	return targetScope(s, kind, name)
+}
+
+// targetKind returns the kind of target the node is, if any
+func targetKind(n ast.Node) string {
+	switch n.(type) {
+	case *ast.ForStmt, *ast.RangeStmt:
+		return "for"
+	case *ast.SwitchStmt:
+		return "switch"
+	case *ast.TypeSwitchStmt:
+		return "type switch"
+	case *ast.SelectStmt:
+		return "select"
+	case *ast.FuncDecl, *ast.FuncLit:
+		return "func"
+	}
+	return ""
+}
+
+// targetScope creates a scope for the body of a target
+func targetScope(s *Scope, kind, label string) *Scope {
+	result := s.New()
+	result.Stash[&targetKey] = &target{kind: kind, label: label, parent: enclosing(s)}
+	if kind == "func" {
+		result.Stash[&labelsKey] = newLabels()
+	}
+	return result
+}
+
+// holeScopes returns the scopes to marshal the args of a quoted
+// snippet with, so that the args see the loops, switch and select
+// statements of the snippet that enclose them.  The labels of the
+// snippet are considered defined and used.
+func holeScopes(s *Scope, n ast.Node, count int) []*Scope {
+	labeled := map[ast.Node]string{}
+	// the snippet itself can be within Labeled but the args are not
+	if name, ok := s.Stash[&labelKey].(string); ok {
+		labeled[n] = name
+		s = s.New()
+		s.Stash[&labelKey] = ""
+	}
+
+	result := make([]*Scope, count)
+	for kk := range result {
+		result[kk] = s
+	}
+	scopes := []*Scope{s}
+	ast.Inspect(n, func(x ast.Node) bool {
+		if x == nil {
+			scopes = scopes[:len(scopes)-1]
+			return true
+		}
+		current := scopes[len(scopes)-1]
+		switch x := x.(type) {
+		case *ast.LabeledStmt:
+			labeled[x.Stmt] = x.Label.Name
+			l := funcLabels(current)
+			l.defined[x.Label.Name] = true
+			l.used[x.Label.Name] = true
+		case *ast.Ident:
+			if idx := holeIndex(x); idx >= 0 && idx < count {
+				result[idx] = current
+			}
+		}
+		if kind := targetKind(x); kind != "" {
+			current = targetScope(current, kind, labeled[x])
+		}
+		scopes = append(scopes, current)
+		return true
+	})
+	return result
+}
+
+// checkLabels checks that the labels of the function body are used
+// and that the gotos refer to defined labels
+func checkLabels(s *Scope) {
+	l := s.Stash[&labelsKey].(*labels)
+	for _, name := range l.gotos {
+		if !l.defined[name] {
+			s.Errorf("label %s not defined", name)
+		}
+	}
+	names := make([]string, 0, len(l.defined))
+	for name := range l.defined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !l.used[name] {
+			s.Errorf("label %s defined and not used", name)
+		}
+	}
+}
+
+// checkFallthrough checks that the fallthrough statements of the
+// switch body are the last statement of a clause which is not the
+// last one
+func checkFallthrough(s *Scope, body *ast.BlockStmt) {
+	for _, b := range enclosing(s).fallthroughs {
+		placed := false
+		for kk, clause := range body.List {
+			cc, ok := clause.(*ast.CaseClause)
+			if !ok || len(cc.Body) == 0 || cc.Body[len(cc.Body)-1] != b {
+				continue
+			}
+			placed = true
+			if kk == len(body.List)-1 {
+				s.Errorf("cannot fallthrough final case in switch")
+			}
+		}
+		if !placed {
+			s.Errorf("fallthrough statement out of place")
+		}
+	}
+}
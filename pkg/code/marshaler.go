@@ -19,9 +19,17 @@ type NodeMarshaler interface {
 	// Call represents a function call expression
 	Call(args ...NodeMarshaler) NodeMarshaler
 
-	// Then updates the then statement
+	// Then updates the then statement or the body of a case
+	// clause. With else-if chains, it updates the last if
 	Then(stmts ...NodeMarshaler) NodeMarshaler
 
+	// Else adds an else branch to the if statement
+	Else(stmts ...NodeMarshaler) NodeMarshaler
+
+	// ElseIf adds an else-if branch to the if statement. Use
+	// Then to specify the body of the branch
+	ElseIf(cond NodeMarshaler) NodeMarshaler
+
 	// Op represents a binary operation such as "<"
 	Op(op string, o NodeMarshaler) NodeMarshaler
 
@@ -31,6 +39,13 @@ type NodeMarshaler interface {
 	// Star represents a ptr deref
 	Star() NodeMarshaler
 
+	// Addr represents taking the address of the expression
+	Addr() NodeMarshaler
+
+	// Index represents an index expression or the instantiation
+	// of a generic type or func with the provided type args
+	Index(args ...NodeMarshaler) NodeMarshaler
+
 	// Assign represents an assignment op such as ":="
 	// Use code.Assign for multiple simultaneous assignment
 	Assign(op string, o NodeMarshaler) NodeMarshaler
@@ -38,14 +53,54 @@ type NodeMarshaler interface {
 	// WithReceiver adds a receiver to the fn
 	WithReceiver(args ...NodeMarshaler) NodeMarshaler
 
-	// WithParam adds a param to the fn
+	// WithTypeParam adds a type param to the fn, struct or
+	// interface.  The args are the names followed by the constraint
+	WithTypeParam(args ...NodeMarshaler) NodeMarshaler
+
+	// WithParam adds a param to the fn, lambda or func type. The
+	// args are the names followed by the type and a nil tag:
+	//
+	//     fn.WithParam(code.Ident("a"), code.Ident("b"), code.Ident("int"), nil)
 	WithParam(args ...NodeMarshaler) NodeMarshaler
 
-	// WithResult adds a result to the fn
+	// WithVariadic adds the final variadic param to the fn, lambda
+	// or func type.  The args are the optional name followed by
+	// the element type
+	WithVariadic(args ...NodeMarshaler) NodeMarshaler
+
+	// WithResult adds a named result to the fn, lambda or func
+	// type, the args are the same as WithParam.  Named results
+	// are added to the scope
 	WithResult(args ...NodeMarshaler) NodeMarshaler
 
-	// WithBody adds a body to the fn
+	// Returns adds unnamed results of the provided types to the
+	// fn, lambda or func type
+	Returns(types ...NodeMarshaler) NodeMarshaler
+
+	// WithBody adds a body to the fn, lambda or loop, or adds clauses
+	// to the switch or select
 	WithBody(stmt ...NodeMarshaler) NodeMarshaler
+
+	// WithField adds a field to the struct
+	WithField(args ...NodeMarshaler) NodeMarshaler
+
+	// WithMethod adds a method to the interface.  The method
+	// signature is specified using Func
+	WithMethod(fn NodeMarshaler) NodeMarshaler
+
+	// Embed adds embedded fields to the struct or embedded
+	// interfaces to the interface
+	Embed(types ...NodeMarshaler) NodeMarshaler
+
+	// WithComment adds a line comment to the last field, method
+	// or value
+	WithComment(text string) NodeMarshaler
+
+	// WithDoc adds a doc comment to the file or declaration
+	WithDoc(text string) NodeMarshaler
+
+	// WithValue adds a const or var spec to the declaration
+	WithValue(args ...NodeMarshaler) NodeMarshaler
 }
 
 // MarshalerFunc converts a function into a node marshaler
@@ -56,22 +111,19 @@ func MarshalerFunc(fn func(s *Scope) ast.Node) NodeMarshaler {
 type nodef func(s *Scope) ast.Node
 
 func (n nodef) MarshalNode(s *Scope) ast.Node {
-	return n(s)
+	result := n(s)
+	track(s, n, result)
+	return result
 }
 
 func (n nodef) Op(op string, o NodeMarshaler) NodeMarshaler {
-	var tok token.Token
-	for kk := token.ILLEGAL; kk <= token.VAR; kk++ {
-		if kk.String() == op {
-			tok = kk
-		}
-	}
+	tok := opToken(op)
 	return nodef(func(s *Scope) ast.Node {
-		x := n.MarshalNode(s).(ast.Expr)
+		x := expr(s, n)
 		if o == nil {
 			return &ast.UnaryExpr{X: x, Op: tok}
 		}
-		y := o.MarshalNode(s).(ast.Expr)
+		y := expr(s, o)
 		return &ast.BinaryExpr{X: x, Op: tok, Y: y}
 	})
 }
@@ -79,7 +131,7 @@ func (n nodef) Op(op string, o NodeMarshaler) NodeMarshaler {
 func (n nodef) Dot(o string) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
 		return &ast.SelectorExpr{
-			X:   n.MarshalNode(s).(ast.Expr),
+			X:   expr(s, n),
 			Sel: ast.NewIdent(o),
 		}
 	})
@@ -87,7 +139,27 @@ func (n nodef) Dot(o string) NodeMarshaler {
 
 func (n nodef) Star() NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		return &ast.StarExpr{X: n.MarshalNode(s).(ast.Expr)}
+		return &ast.StarExpr{X: expr(s, n)}
+	})
+}
+
+func (n nodef) Addr() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.UnaryExpr{X: expr(s, n), Op: token.AND}
+	})
+}
+
+func (n nodef) Index(args ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		x := expr(s, n)
+		exprs := make([]ast.Expr, len(args))
+		for kk, arg := range args {
+			exprs[kk] = expr(s, arg)
+		}
+		if len(exprs) == 1 {
+			return &ast.IndexExpr{X: x, Index: exprs[0]}
+		}
+		return &ast.IndexListExpr{X: x, Indices: exprs}
 	})
 }
 
@@ -97,17 +169,17 @@ func (n nodef) Assign(op string, o NodeMarshaler) NodeMarshaler {
 
 func (n nodef) Paren() NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		return &ast.ParenExpr{X: n.MarshalNode(s).(ast.Expr)}
+		return &ast.ParenExpr{X: expr(s, n)}
 	})
 
 }
 
 func (n nodef) Call(args ...NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		fn := n.MarshalNode(s).(ast.Expr)
+		fn := expr(s, n)
 		exprs := make([]ast.Expr, len(args))
 		for kk, arg := range args {
-			exprs[kk] = arg.MarshalNode(s).(ast.Expr)
+			exprs[kk] = expr(s, arg)
 		}
 		if len(args) == 0 {
 			exprs = nil
@@ -119,69 +191,284 @@ func (n nodef) Call(args ...NodeMarshaler) NodeMarshaler {
 
 func (n nodef) Then(stmts ...NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		s = s.New()
-		ifstmt := n.MarshalNode(s).(*ast.IfStmt)
+		s = chainScope(s)
+		result := n.MarshalNode(s)
+		body := block(s.New(), stmts)
+		switch x := result.(type) {
+		case *ast.IfStmt:
+			lastIf(x).Body = body
+		case *ast.CaseClause:
+			x.Body = body.List
+		case *ast.CommClause:
+			x.Body = body.List
+		default:
+			s.unexpected(n, result, "an if statement or a clause")
+		}
+		return result
+	})
+}
 
-		s = s.New()
-		block := &ast.BlockStmt{}
-		for _, stmt := range stmts {
-			nn := stmt.MarshalNode(s)
-			if x, ok := nn.(ast.Expr); ok {
-				block.List = append(block.List, &ast.ExprStmt{X: x})
-			} else {
-				block.List = append(block.List, nn.(ast.Stmt))
-			}
+func (n nodef) Else(stmts ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		s = chainScope(s)
+		result := n.MarshalNode(s)
+		ifstmt, ok := result.(*ast.IfStmt)
+		if !ok {
+			s.unexpected(n, result, "an if statement")
+			return result
+		}
+		lastIf(ifstmt).Else = block(s.New(), stmts)
+		return ifstmt
+	})
+}
+
+func (n nodef) ElseIf(cond NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		s = chainScope(s)
+		result := n.MarshalNode(s)
+		ifstmt, ok := result.(*ast.IfStmt)
+		if !ok {
+			s.unexpected(n, result, "an if statement")
+			return result
 		}
-		ifstmt.Body = block
+		lastIf(ifstmt).Else = &ast.IfStmt{Cond: expr(s, cond)}
 		return ifstmt
 	})
 }
 
+// lastIf returns the last if statement in an else-if chain
+func lastIf(ifstmt *ast.IfStmt) *ast.IfStmt {
+	for {
+		next, ok := ifstmt.Else.(*ast.IfStmt)
+		if !ok {
+			return ifstmt
+		}
+		ifstmt = next
+	}
+}
+
+// chainScope returns the scope shared by all the parts of an if-else
+// chain, creating it if needed. This allows the else branches to see
+// variables declared in the init statement of the if.
+func chainScope(s *Scope) *Scope {
+	if _, ok := s.Stash[&chainKey]; ok {
+		return s
+	}
+	s = s.New()
+	s.Stash[&chainKey] = true
+	return s
+}
+
+var chainKey = "chain"
+
 func (n nodef) WithReceiver(args ...NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		fn := n.MarshalNode(s).(*ast.FuncDecl)
+		result := n.MarshalNode(s)
+		fn, ok := result.(*ast.FuncDecl)
+		if !ok {
+			s.unexpected(n, result, "a func")
+			return result
+		}
+		if fn.Recv == nil {
+			fn.Recv = &ast.FieldList{}
+		}
 		fn.Recv.List = append(fn.Recv.List, field(s, args...))
 		return fn
 	})
 }
 
+func (n nodef) WithTypeParam(args ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		result := n.MarshalNode(s)
+		var params **ast.FieldList
+		switch x := result.(type) {
+		case *ast.FuncDecl:
+			params = &x.Type.TypeParams
+		case *ast.GenDecl:
+			var spec *ast.TypeSpec
+			if len(x.Specs) > 0 {
+				spec, _ = x.Specs[len(x.Specs)-1].(*ast.TypeSpec)
+			}
+			if spec == nil {
+				s.unexpected(n, result, "a type declaration")
+				return result
+			}
+			params = &spec.TypeParams
+			// type params are only visible within the type
+			s = s.New()
+		default:
+			s.unexpected(n, result, "a func or a type declaration")
+			return result
+		}
+		if *params == nil {
+			*params = &ast.FieldList{}
+		}
+		// field expects the last arg to be the tag
+		f := field(s, append(args, nil)...)
+		(*params).List = append((*params).List, f)
+		return result
+	})
+}
+
 func (n nodef) WithParam(args ...NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		fn := n.MarshalNode(s).(*ast.FuncDecl)
-		fn.Type.Params.List = append(fn.Type.Params.List, field(s, args...))
+		fn := n.MarshalNode(s)
+		ft := funcType(s, n, fn)
+		if isVariadic(ft) {
+			s.Errorf("param added after the variadic param")
+		}
+		f := field(s, args...)
+		if mixesNames(ft.Params.List, f) {
+			s.Errorf("cannot mix named and unnamed params")
+		}
+		ft.Params.List = append(ft.Params.List, f)
+		return fn
+	})
+}
+
+func (n nodef) WithVariadic(args ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		fn := n.MarshalNode(s)
+		ft := funcType(s, n, fn)
+		if isVariadic(ft) {
+			s.Errorf("param added after the variadic param")
+		}
+		if len(args) == 0 || len(args) > 2 {
+			s.Errorf("expected an optional name and a type, got %d args", len(args))
+			return fn
+		}
+		elt := Ellipsis(args[len(args)-1])
+		f := field(s, append(append([]NodeMarshaler{}, args[:len(args)-1]...), elt, nil)...)
+		if mixesNames(ft.Params.List, f) {
+			s.Errorf("cannot mix named and unnamed params")
+		}
+		ft.Params.List = append(ft.Params.List, f)
 		return fn
 	})
 }
 
 func (n nodef) WithResult(args ...NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
-		fn := n.MarshalNode(s).(*ast.FuncDecl)
-		fn.Type.Results.List = append(fn.Type.Results.List, field(s, args...))
+		fn := n.MarshalNode(s)
+		ft := funcType(s, n, fn)
+		f := field(s, args...)
+		if mixesNames(ft.Results.List, f) {
+			s.Errorf("cannot mix named and unnamed results")
+		}
+		ft.Results.List = append(ft.Results.List, f)
+		return fn
+	})
+}
+
+func (n nodef) Returns(types ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		fn := n.MarshalNode(s)
+		ft := funcType(s, n, fn)
+		if results := ft.Results.List; len(results) > 0 && len(results[0].Names) > 0 {
+			s.Errorf("cannot mix named and unnamed results")
+		}
+		for _, t := range types {
+			ft.Results.List = append(ft.Results.List, &ast.Field{Type: expr(s, t)})
+		}
 		return fn
 	})
 }
 
+// mixesNames checks if adding the field to the list mixes named and
+// unnamed fields
+func mixesNames(list []*ast.Field, f *ast.Field) bool {
+	return len(list) > 0 && (len(list[0].Names) == 0) != (len(f.Names) == 0)
+}
+
+// isVariadic checks if the last param is variadic
+func isVariadic(ft *ast.FuncType) bool {
+	if n := len(ft.Params.List); n > 0 {
+		_, ok := ft.Params.List[n-1].Type.(*ast.Ellipsis)
+		return ok
+	}
+	return false
+}
+
 func (n nodef) WithBody(stmts ...NodeMarshaler) NodeMarshaler {
 	return nodef(func(s *Scope) ast.Node {
 		s = s.New()
-		fn := n.MarshalNode(s).(*ast.FuncDecl)
-		s = s.New()
-		block := &ast.BlockStmt{}
-		for _, stmt := range stmts {
-			nn := stmt.MarshalNode(s)
-			if x, ok := nn.(ast.Expr); ok {
-				block.List = append(block.List, &ast.ExprStmt{X: x})
-			} else {
-				block.List = append(block.List, nn.(ast.Stmt))
-			}
+		result := n.MarshalNode(s)
+		if fn, ok := result.(*ast.FuncDecl); ok {
+			declareFunc(s, fn)
 		}
-		fn.Body = block
-		return fn
+		bs := bodyScope(s, result)
+		body := block(bs, stmts)
+		switch x := result.(type) {
+		case *ast.FuncDecl:
+			x.Body = body
+			checkLabels(bs)
+		case *ast.FuncLit:
+			x.Body = body
+			checkLabels(bs)
+		case *ast.ForStmt:
+			x.Body = body
+		case *ast.RangeStmt:
+			x.Body = body
+		case *ast.SwitchStmt:
+			x.Body = body
+			checkFallthrough(bs, body)
+		case *ast.TypeSwitchStmt:
+			x.Body = body
+		case *ast.SelectStmt:
+			x.Body = commClauses(body)
+		default:
+			s.unexpected(n, result, "a func, loop, switch or select")
+		}
+		return result
 	})
 }
 
+// funcType returns the signature of a func decl, func literal or
+// func type marshaled by m
+func funcType(s *Scope, m NodeMarshaler, n ast.Node) *ast.FuncType {
+	switch x := n.(type) {
+	case *ast.FuncType:
+		return x
+	case *ast.FuncDecl:
+		return x.Type
+	case *ast.FuncLit:
+		return x.Type
+	}
+	s.unexpected(m, n, "a func")
+	return &ast.FuncType{Params: &ast.FieldList{}, Results: &ast.FieldList{}}
+}
+
+// block marshals the statements into a block
+func block(s *Scope, stmts []NodeMarshaler) *ast.BlockStmt {
+	result := &ast.BlockStmt{}
+	for _, st := range stmts {
+		result.List = append(result.List, stmt(s, st))
+	}
+	return result
+}
+
+// stmt marshals the node as a statement
+func stmt(s *Scope, m NodeMarshaler) ast.Stmt {
+	return toStmt(s, m, m.MarshalNode(s))
+}
+
+// toStmt converts the node marshaled by m into a statement,
+// wrapping expressions and declarations
+func toStmt(s *Scope, m NodeMarshaler, n ast.Node) ast.Stmt {
+	switch x := n.(type) {
+	case ast.Stmt:
+		return x
+	case ast.Expr:
+		return &ast.ExprStmt{X: x}
+	case *ast.GenDecl:
+		return &ast.DeclStmt{Decl: x}
+	}
+	s.unexpected(m, n, "a statement")
+	return &ast.BadStmt{}
+}
+
 func field(s *Scope, args ...NodeMarshaler) *ast.Field {
-	f := &ast.Field{Names: []*ast.Ident{}}
+	f := &ast.Field{}
 
 	for kk, arg := range args {
 		if arg == nil {
@@ -194,14 +481,22 @@ func field(s *Scope, args ...NodeMarshaler) *ast.Field {
 
 		switch kk {
 		case len(args) - 1:
-			f.Tag = n.(*ast.BasicLit)
+			if tag, ok := n.(*ast.BasicLit); ok {
+				f.Tag = tag
+			} else {
+				s.unexpected(arg, n, "a tag")
+			}
 		case len(args) - 2:
-			f.Type = n.(ast.Expr)
+			f.Type = toExpr(s, arg, n)
 		default:
-			nn := n.(*ast.Ident)
+			nn := toIdent(s, arg, n)
+			renameReceiver(s, nn.Name)
 			s.Vars[nn.Name] = nn
 			f.Names = append(f.Names, nn)
 		}
 	}
+	for _, name := range f.Names {
+		s.declareVar(name.Name, f.Type, nil)
+	}
 	return f
 }
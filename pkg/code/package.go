@@ -0,0 +1,184 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+)
+
+// Package creates a package with the provided name. Files are added
+// to it using WithFile:
+//
+//	code.Package("example").
+//	    WithFile("types.go", code.Struct("Foo")).
+//	    WithFile("funcs.go", code.Func("NewFoo")).
+//	    Write("./example")
+//
+// All the files share the package level names: a name picked in
+// one file will not collide with a declaration in another.  Imports
+// are tracked per file.
+func Package(name string) *Pkg {
+	return &Pkg{Name: name}
+}
+
+// Pkg holds the files of a package
+type Pkg struct {
+	Name  string
+	Files []PkgFile
+}
+
+// PkgFile is a single file of a package
+type PkgFile struct {
+	Name     string
+	Contents []NodeMarshaler
+}
+
+// WithFile adds a file with the provided contents to the package
+func (p *Pkg) WithFile(name string, contents ...NodeMarshaler) *Pkg {
+	p.Files = append(p.Files, PkgFile{Name: name, Contents: contents})
+	return p
+}
+
+// MarshalFiles marshals all the files of the package using the
+// provided scope for the package level names. The result is indexed
+// by the file name.
+func (p *Pkg) MarshalFiles(s *Scope) map[string]*ast.File {
+	result := map[string]*ast.File{}
+	for _, f := range p.Files {
+		node := File(p.Name, f.Contents...).MarshalNode(fileScope(s))
+		result[f.Name] = node.(*ast.File)
+	}
+	return result
+}
+
+// Render marshals the files of the package using a fresh root scope
+// and returns the formatted source of each, indexed by the file
+// name.
+func (p *Pkg) Render() (map[string][]byte, error) {
+	s := RootScope()
+	result := map[string][]byte{}
+	for _, f := range p.Files {
+		file := File(p.Name, f.Contents...)
+		node, err := MarshalNodeErr(file, fileScope(s))
+		if err == nil {
+			result[f.Name], err = formatFile(node, localPrefix(s, node))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// Write renders all the files and writes them to the provided
+// directory, creating it if needed.
+//
+// Either all the files are written or none are: the files are first
+// written to temporary files in the same directory and then renamed.
+// If a rename fails, the files replaced so far are restored.
+func (p *Pkg) Write(dir string) (err error) {
+	sources, err := p.Render()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	temps := map[string]string{}
+	backups := map[string]string{}
+	var replaced []string
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+		if err != nil {
+			for kk := len(replaced) - 1; kk >= 0; kk-- {
+				restore(filepath.Join(dir, replaced[kk]), backups[replaced[kk]])
+			}
+		}
+		for _, backup := range backups {
+			os.Remove(backup)
+		}
+	}()
+
+	for _, f := range p.Files {
+		temp, err := writeTemp(dir, sources[f.Name])
+		if err != nil {
+			return err
+		}
+		temps[f.Name] = temp
+	}
+
+	for _, f := range p.Files {
+		target := filepath.Join(dir, f.Name)
+		if _, err := os.Lstat(target); err == nil {
+			name, err := backup(dir, target)
+			if err != nil {
+				return err
+			}
+			backups[f.Name] = name
+		}
+		replaced = append(replaced, f.Name)
+		if err := os.Rename(temps[f.Name], target); err != nil {
+			return err
+		}
+		delete(temps, f.Name)
+	}
+	return nil
+}
+
+// backup moves the file out of the way, returning the backup name
+func backup(dir, target string) (string, error) {
+	name, err := writeTemp(dir, nil)
+	if err == nil {
+		if err = os.Rename(target, name); err != nil {
+			os.Remove(name)
+			return "", err
+		}
+	}
+	return name, err
+}
+
+// restore puts back the original file or removes the new one if
+// there was no original
+func restore(target, backup string) {
+	if backup == "" {
+		os.Remove(target)
+	} else {
+		os.Rename(backup, target)
+	}
+}
+
+func writeTemp(dir string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, ".gogo-*.go")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// fileScope creates the scope for a file of a package.  The file
+// scope has its own stash but shares the package level names.
+func fileScope(s *Scope) *Scope {
+	result := s.New()
+	result.Vars = s.Vars
+	result.Stash[&typesKey] = s.typeInfo()
+	return result
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/tvastar/gogo/pkg/code"
+
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "example")
+	pkg := code.Package("example").
+		WithFile("a.go", code.Var().WithValue(code.IdentPrefix("x"), code.Import("strings").Dot("Builder"), nil)).
+		WithFile("b.go", code.Var().WithValue(code.IdentPrefix("x"), code.Ident("int"), nil))
+
+	if err := pkg.Write(dir); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := map[string]string{
+		"a.go": "package example\n\nimport \"strings\"\n\nvar x strings.Builder\n",
+		"b.go": "package example\n\nvar x2 int\n",
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != len(expected) {
+		t.Fatal("unexpected files", entries, err)
+	}
+	for name, src := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if diff := cmp.Diff(src, string(data)); diff != "" {
+			t.Error(name, "mismatch", diff)
+		}
+	}
+}
+
+func TestPackageErrors(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "example")
+	pkg := code.Package("example").
+		WithFile("a.go", code.Var().WithValue(code.Ident("x"), code.Ident("int"), nil)).
+		WithFile("b.go", code.Return(code.If(code.Ident("x"))))
+
+	if err := pkg.Write(dir); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("unexpected files written", err)
+	}
+}
+
+func TestPackageFuncNames(t *testing.T) {
+	pkg := code.Package("example").
+		WithFile("a.go",
+			code.Func("helper").WithBody(),
+			code.Interface("Reader").WithMethod(code.Func("Read")),
+			code.Func("Close").WithReceiver(nil, code.Ident("T"), nil).WithBody(),
+			code.Struct("T"),
+		).
+		WithFile("b.go",
+			code.Var().WithValue(code.IdentPrefix("helper"), nil, code.Literal(1)),
+			code.Var().WithValue(code.IdentPrefix("Read"), nil, code.Literal(2)),
+			code.Var().WithValue(code.IdentPrefix("Close"), nil, code.Literal(3)),
+		)
+
+	files, err := pkg.Render()
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := "package example\n\nvar helper2 = 1\nvar Read = 2\nvar Close = 3\n"
+	if diff := cmp.Diff(expected, string(files["b.go"])); diff != "" {
+		t.Error("mismatch", diff)
+	}
+	if err := pkg.Check(nil); err != nil {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestPackageWriteRestores(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// b.go cannot be replaced as it is a non-empty directory
+	if err := os.MkdirAll(filepath.Join(dir, "b.go", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := code.Package("example").
+		WithFile("a.go", code.Var().WithValue(code.Ident("x"), code.Ident("int"), nil)).
+		WithFile("b.go", code.Var().WithValue(code.Ident("y"), code.Ident("int"), nil))
+	if err := pkg.Write(dir); err == nil {
+		t.Fatal("expected error")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.go"))
+	if err != nil || string(data) != "old" {
+		t.Error("a.go was not restored", string(data), err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Error("unexpected files", entries, err)
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Expr parses a Go expression, splicing in the args in place of the
+// %v placeholders:
+//
+//	code.Expr("x + len(%v)", items)
+//
+// Use %% for a literal percent sign.  String and rune literals are
+// left as is, so "%v" within them is not a placeholder.
+//
+// The args are marshaled with the identifiers used by the snippet
+// reserved, so an IdentPrefix arg never picks a name that the
+// snippet already uses.
+func Expr(src string, args ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return quote(s, src, args, &ast.BadExpr{}, func(src string) (ast.Node, error) {
+			return parser.ParseExpr(src)
+		})
+	})
+}
+
+// Stmt parses a single Go statement, splicing in the args in place
+// of the %v placeholders. A placeholder used as a statement can be
+// replaced with any statement:
+//
+//	code.Stmt("for _, %v := range items { %v }", item, body)
+//
+// Variables declared by the statement are added to the scope. See
+// Expr for details.
+func Stmt(src string, args ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return quote(s, src, args, &ast.BadStmt{}, func(src string) (ast.Node, error) {
+			f, err := parser.ParseFile(token.NewFileSet(), "", "package p; func _() {\n"+src+"\n}", 0)
+			if err != nil {
+				return nil, err
+			}
+			body := f.Decls[0].(*ast.FuncDecl).Body.List
+			if len(body) != 1 {
+				return nil, fmt.Errorf("expected one statement, found %d", len(body))
+			}
+			return body[0], nil
+		})
+	})
+}
+
+// Decl parses a single Go declaration, splicing in the args in place
+// of the %v placeholders:
+//
+//	code.Decl("type %v map[string]int", code.Ident("Counts"))
+//
+// The declared names are added to the scope. See Expr for details.
+func Decl(src string, args ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return quote(s, src, args, &ast.BadDecl{}, func(src string) (ast.Node, error) {
+			f, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+src, 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(f.Decls) != 1 {
+				return nil, fmt.Errorf("expected one declaration, found %d", len(f.Decls))
+			}
+			return f.Decls[0], nil
+		})
+	})
+}
+
+// quote parses the snippet on every marshal so that each result is
+// a fresh AST which can be modified by the chained builders.  The
+// bad node is returned if the snippet cannot be parsed.
+func quote(s *Scope, src string, args []NodeMarshaler, bad ast.Node, parse func(string) (ast.Node, error)) ast.Node {
+	text, holes := placeholders(src)
+	if holes != len(args) {
+		s.Errorf("%q has %d placeholders but %d args", src, holes, len(args))
+		return bad
+	}
+	n, err := parse(text)
+	if err != nil {
+		s.Errorf("%q: %v", src, err)
+		return bad
+	}
+	return splice(s, n, args)
+}
+
+// splice marshals the args and replaces the placeholders in n
+func splice(s *Scope, n ast.Node, args []NodeMarshaler) ast.Node {
+	// reserve the identifiers of the snippet while marshaling
+	var reserved []string
+	fields := map[*ast.Ident]bool{}
+	ast.Inspect(n, func(x ast.Node) bool {
+		switch x := x.(type) {
+		case *ast.SelectorExpr:
+			fields[x.Sel] = true
+		case *ast.Ident:
+			if holeIndex(x) < 0 && !fields[x] && !isVar(s, x.Name) {
+				s.Vars[x.Name] = x
+				reserved = append(reserved, x.Name)
+			}
+		}
+		return true
+	})
+
+	values := make([]ast.Node, len(args))
+	scopes := holeScopes(s, n, len(args))
+	for kk, arg := range args {
+		values[kk] = arg.MarshalNode(scopes[kk])
+	}
+
+	for _, name := range reserved {
+		delete(s.Vars, name)
+	}
+
+	spliced := map[int]bool{}
+	n = astutil.Apply(n, func(c *astutil.Cursor) bool {
+		switch x := c.Node().(type) {
+		case *ast.ExprStmt:
+			if id, ok := x.X.(*ast.Ident); ok && holeIndex(id) >= 0 {
+				idx := holeIndex(id)
+				c.Replace(toStmt(s, args[idx], values[idx]))
+				spliced[idx] = true
+				return false
+			}
+		case *ast.Ident:
+			if idx := holeIndex(x); idx >= 0 {
+				c.Replace(toExpr(s, args[idx], values[idx]))
+				spliced[idx] = true
+			}
+		}
+		return true
+	}, nil)
+	// placeholders in comments are dropped by the parser
+	for idx := range args {
+		if !spliced[idx] {
+			s.Errorf("placeholder %d is not in an expression or statement position", idx+1)
+		}
+	}
+	declareAll(s, n)
+	return n
+}
+
+// declareAll adds the names declared by the snippet to the scope
+func declareAll(s *Scope, n ast.Node) {
+	add := func(exprs ...ast.Expr) {
+		for _, x := range exprs {
+			if id, ok := x.(*ast.Ident); ok && id.Name != "_" {
+				s.Vars[id.Name] = id
+			}
+		}
+	}
+
+	ast.Inspect(n, func(x ast.Node) bool {
+		switch x := x.(type) {
+		case *ast.AssignStmt:
+			if x.Tok == token.DEFINE {
+				add(x.Lhs...)
+				if len(x.Lhs) == len(x.Rhs) {
+					for kk, l := range x.Lhs {
+						if id, ok := l.(*ast.Ident); ok {
+							s.declareVar(id.Name, nil, x.Rhs[kk])
+						}
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for kk, name := range x.Names {
+				add(name)
+				var value ast.Expr
+				if len(x.Names) == len(x.Values) {
+					value = x.Values[kk]
+				}
+				s.declareVar(name.Name, x.Type, value)
+			}
+		case *ast.TypeSpec:
+			add(x.Name)
+			s.declareType(x)
+		case *ast.FuncDecl:
+			add(x.Name)
+			s.declareVar(x.Name.Name, x.Type, nil)
+		case *ast.BlockStmt, *ast.FuncLit, *ast.RangeStmt, *ast.ForStmt,
+			*ast.IfStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt,
+			*ast.CaseClause, *ast.CommClause:
+			// nested scopes, including the init statements
+			return false
+		}
+		return true
+	})
+}
+
+// placeholders replaces %v with unique identifiers and %% with %.
+// String and rune literals are copied as is.
+func placeholders(src string) (string, int) {
+	var buf strings.Builder
+	holes := 0
+	for kk := 0; kk < len(src); kk++ {
+		switch {
+		case src[kk] == '"' || src[kk] == '\'' || src[kk] == '`':
+			end := literalEnd(src, kk)
+			buf.WriteString(src[kk:end])
+			kk = end - 1
+		case strings.HasPrefix(src[kk:], "%%"):
+			buf.WriteByte('%')
+			kk++
+		case strings.HasPrefix(src[kk:], "%v"):
+			buf.WriteString(holePrefix + strconv.Itoa(holes))
+			holes++
+			kk++
+		default:
+			buf.WriteByte(src[kk])
+		}
+	}
+	return buf.String(), holes
+}
+
+// literalEnd returns the end of the string or rune literal starting
+// at start.  Unterminated literals extend to the end of src and are
+// reported by the parser.
+func literalEnd(src string, start int) int {
+	quote := src[start]
+	for kk := start + 1; kk < len(src); kk++ {
+		switch {
+		case src[kk] == '\\' && quote != '`':
+			kk++
+		case src[kk] == quote:
+			return kk + 1
+		}
+	}
+	return len(src)
+}
+
+func holeIndex(id *ast.Ident) int {
+	if !strings.HasPrefix(id.Name, holePrefix) {
+		return -1
+	}
+	idx, err := strconv.Atoi(id.Name[len(holePrefix):])
+	if err != nil {
+		return -1
+	}
+	return idx
+}
+
+const holePrefix = "gogo__hole"
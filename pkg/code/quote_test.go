@@ -0,0 +1,95 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/tvastar/gogo/pkg/code"
+
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	items := code.IdentPrefix("items")
+	x := code.IdentPrefix("x")
+	file := code.File("example",
+		code.Decl("type %v map[string]int", code.Ident("Counts")),
+		code.Func("count").
+			WithParam(items, code.SliceOf(code.Ident("string")), nil).
+			WithResult(nil, code.Ident("int"), nil).
+			WithBody(
+				code.Stmt("x := 0"),
+				code.Stmt("for _, %v := range %v { %v }",
+					x, items, code.Stmt("x += len(%v) %% 5", x),
+				),
+				code.Return(code.Expr("x * %v", code.Literal(2))),
+			),
+	)
+	src, err := code.Render(file)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := `package example
+
+type Counts map[string]int
+
+func count(items []string) int {
+	x := 0
+	for _, x2 := range items {
+		x += len(x2) % 5
+	}
+	return x * 2
+}
+`
+	if diff := cmp.Diff(expected, string(src)); diff != "" {
+		t.Error("mismatch", diff)
+	}
+
+	validate(t, "literals", "println(\"%v %%\", '%', `%v`, y)",
+		code.Stmt("println(\"%v %%\", '%', `%v`, %v)", code.Ident("y")))
+}
+
+func TestQuoteScopes(t *testing.T) {
+	g := code.Ident("g").Call()
+	validateRender(t, "if init", "func f() {\n\tif err := g(); err != nil {\n\t\treturn\n\t}\n\terr := g()\n}",
+		code.Func("f").WithBody(
+			code.Stmt("if err := g(); err != nil { return }"),
+			code.Assign(":=", code.Ident("err"), g),
+		))
+	validateRender(t, "type switch", "func f(x any) {\n\tswitch v := x.(type) {\n\tdefault:\n\t\t_ = v\n\t}\n\tv := 1\n}",
+		code.Func("f").WithParam(code.Ident("x"), code.Ident("any"), nil).WithBody(
+			code.Stmt("switch v := x.(type) { default: _ = v }"),
+			code.Assign(":=", code.Ident("v"), code.Literal(1)),
+		))
+}
+
+func TestQuoteErrors(t *testing.T) {
+	_, err := code.Render(code.Expr("x + %v"))
+	if err == nil || !strings.Contains(err.Error(), "1 placeholders but 0 args") {
+		t.Error("unexpected error", err)
+	}
+
+	_, err = code.Render(code.Stmt("x := "))
+	if err == nil || !strings.Contains(err.Error(), "Stmt") {
+		t.Error("unexpected error", err)
+	}
+
+	_, err = code.Render(code.Stmt(`println("%v", %v)`, code.Ident("x"), code.Ident("y")))
+	if err == nil || !strings.Contains(err.Error(), "1 placeholders but 2 args") {
+		t.Error("unexpected error", err)
+	}
+
+	_, err = code.Render(code.Stmt("x := 1 /* %v */", code.Ident("y")))
+	if err == nil || !strings.Contains(err.Error(), "placeholder 1 is not in an expression or statement position") {
+		t.Error("unexpected error", err)
+	}
+
+	_, err = code.Render(code.Decl("type x int; type y int"))
+	if err == nil || !strings.Contains(err.Error(), "expected one declaration") {
+		t.Error("unexpected error", err)
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"io"
+)
+
+// Render marshals the node with a fresh root scope and returns the
+// formatted Go source.
+//
+// Comments are preserved and the import groups are separated (see
+// Format and File).  If the node cannot be marshaled
+// or if the generated AST is not valid Go, the returned error
+// describes the builder or the source line at fault.
+func Render(m NodeMarshaler) ([]byte, error) {
+	s := RootScope()
+	node, err := MarshalNodeErr(m, s)
+	if err != nil {
+		return nil, err
+	}
+	return formatFile(node, localPrefix(s, node))
+}
+
+// RenderTo is like Render but writes the output to w
+func RenderTo(w io.Writer, m NodeMarshaler) error {
+	src, err := Render(m)
+	if err == nil {
+		_, err = w.Write(src)
+	}
+	return err
+}
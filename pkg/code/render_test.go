@@ -0,0 +1,131 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/tvastar/gogo/pkg/code"
+
+	"bytes"
+	"go/ast"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	err := code.RenderTo(&buf, code.File("x",
+		code.Var().WithValue(code.Ident("x"), code.Import("strings").Dot("Builder"), nil),
+		code.Var().WithValue(code.Ident("y"), code.Import("bytes").Dot("Buffer"), nil),
+	))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := "package x\n\nimport (\n\t\"bytes\"\n\t\"strings\"\n)\n\nvar x strings.Builder\nvar y bytes.Buffer\n"
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Error("mismatch", diff)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	_, err := code.Render(code.Return(code.If(code.Ident("x"))))
+	if err == nil || !strings.Contains(err.Error(), "Return") {
+		t.Error("unexpected error", err)
+	}
+
+	_, err = code.Render(code.File("x", code.Var().WithValue(code.Ident("x-y"), nil, code.Literal(5))))
+	if err == nil || !strings.Contains(err.Error(), "var x-y = 5") {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestMarshalNodeErr(t *testing.T) {
+	file := code.File("x",
+		code.Ident("x").Op("+", code.If(code.Ident("y"))),
+		code.Func("f").WithBody(code.ImportBlank("embed")),
+		code.Var().WithDoc("doc").Then(code.Ident("z")),
+		code.Func("g").WithBody(code.Assign("=", code.Ident("x"))),
+	)
+	_, err := code.MarshalNodeErr(file, code.RootScope())
+	expected := []string{
+		"code: Op: expected an expression but If produced *ast.IfStmt",
+		"code: File: expected a declaration but Op produced *ast.BinaryExpr (x + BadExpr)",
+		"code: WithBody: expected a statement but ImportBlank produced nil",
+		"code: Then: expected an if statement or a clause but WithDoc produced *ast.GenDecl (var ())",
+		"code: Assign: expected pairs of variables and values but got 1 args",
+	}
+	errs, ok := err.(code.Errors)
+	if !ok {
+		t.Fatal("unexpected error", err)
+	}
+	actual := make([]string, len(errs))
+	for kk, e := range errs {
+		actual[kk] = e.Error()
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error("mismatch", diff)
+	}
+
+	s := code.RootScope()
+	code.MarshalerFunc(func(s *code.Scope) ast.Node {
+		s.Errorf("custom %d", 42)
+		return nil
+	}).MarshalNode(s)
+	if err := s.Err(); err == nil || !strings.HasSuffix(err.Error(), ": custom 42") {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestImportGroups(t *testing.T) {
+	value := func(name string, typ code.NodeMarshaler) code.NodeMarshaler {
+		return code.Var().WithValue(code.Ident(name), typ, nil)
+	}
+
+	file := code.LocalImports("github.com/tvastar/", code.File("x",
+		value("a", code.Import("github.com/tvastar/gogo/pkg/router").Dot("Config")),
+		value("b", code.Import("strings").Dot("Builder")),
+		value("c", code.Import("github.com/google/go-cmp/cmp").Dot("Option")),
+		value("d", code.Import("bytes").Dot("Buffer")),
+		value("e", code.Import("go/ast").Dot("Node")),
+		value("f", code.Import("github.com/tvastarx/pkg").Dot("T")),
+		code.Func("unused").WithBody(code.MarshalerFunc(func(s *code.Scope) ast.Node {
+			code.Import("net/http").MarshalNode(s)
+			return code.Return().MarshalNode(s)
+		})),
+	))
+	src, err := code.Render(file)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := `package x
+
+import (
+	"bytes"
+	"go/ast"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tvastarx/pkg"
+
+	"github.com/tvastar/gogo/pkg/router"
+)
+
+var a router.Config
+var b strings.Builder
+var c cmp.Option
+var d bytes.Buffer
+var e ast.Node
+var f pkg.T
+
+func unused() {
+	return
+}
+`
+	if diff := cmp.Diff(expected, string(src)); diff != "" {
+		t.Error("mismatch", diff)
+	}
+}
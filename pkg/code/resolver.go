@@ -0,0 +1,164 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"errors"
+	"go/ast"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// ImportResolver resolves the package name of an import path.  It
+// is used by Import to pick the name to refer to a package by.
+//
+// The resolver used is configured on the scope with
+// UseImportResolver and defaults to DefaultResolver.
+type ImportResolver interface {
+	ResolveImport(path string) (string, error)
+}
+
+// ImportResolverFunc converts a function into an ImportResolver
+type ImportResolverFunc func(path string) (string, error)
+
+// ResolveImport calls the underlying function
+func (fn ImportResolverFunc) ResolveImport(path string) (string, error) {
+	return fn(path)
+}
+
+// ImportNames is an ImportResolver with an explicit map of import
+// paths to package names.  It fails for paths not in the map.
+type ImportNames map[string]string
+
+// ResolveImport looks up the path in the map
+func (names ImportNames) ResolveImport(path string) (string, error) {
+	if name, ok := names[path]; ok {
+		return name, nil
+	}
+	return "", errUnresolved
+}
+
+// Stdlib resolves the standard library packages using a builtin
+// table. It fails for all other paths.
+var Stdlib ImportResolver = ImportNames(stdlib)
+
+// Heuristic guesses the package name from the import path the same
+// way goimports does: major version suffixes such as "/v2" are
+// skipped, a "go-" prefix is removed and the name is cut at the
+// first character that is not valid in an identifier.  So
+// "gopkg.in/yaml.v2" is "yaml" and "github.com/mattn/go-sqlite3/v3"
+// is "sqlite3".
+var Heuristic ImportResolver = ImportResolverFunc(assumedName)
+
+// Packages resolves import paths by loading the packages with "go
+// list".  It is accurate but slow and depends on the environment.
+var Packages ImportResolver = ImportResolverFunc(loadName)
+
+// DefaultResolver uses Stdlib falling back to Heuristic
+var DefaultResolver = Resolvers(Stdlib, Heuristic)
+
+// Resolvers combines multiple resolvers, using the first one that
+// succeeds.
+//
+// Overrides can be provided by placing an ImportNames first:
+//
+//	code.Resolvers(code.ImportNames{"github.com/x/y": "z"}, code.DefaultResolver)
+func Resolvers(resolvers ...ImportResolver) ImportResolver {
+	return ImportResolverFunc(func(path string) (string, error) {
+		for _, r := range resolvers {
+			if name, err := r.ResolveImport(path); err == nil {
+				return name, nil
+			}
+		}
+		return "", errUnresolved
+	})
+}
+
+// Cache caches the results of the resolver. It is safe for
+// concurrent use.
+func Cache(r ImportResolver) ImportResolver {
+	var mu sync.Mutex
+	cache := map[string]string{}
+	return ImportResolverFunc(func(path string) (string, error) {
+		mu.Lock()
+		name, ok := cache[path]
+		mu.Unlock()
+		if ok {
+			return name, nil
+		}
+
+		name, err := r.ResolveImport(path)
+		if err == nil {
+			mu.Lock()
+			cache[path] = name
+			mu.Unlock()
+		}
+		return name, err
+	})
+}
+
+// UseImportResolver marshals the node using the provided resolver
+// for all imports within it:
+//
+//	code.Render(code.UseImportResolver(code.Packages, code.File(...)))
+func UseImportResolver(r ImportResolver, n NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		s = s.New()
+		s.Stash[&resolverKey] = r
+		return n.MarshalNode(s)
+	})
+}
+
+// ImportResolver returns the resolver configured for the scope
+func (s *Scope) ImportResolver() ImportResolver {
+	if r, ok := s.LookupStash(&resolverKey); ok {
+		return r.(ImportResolver)
+	}
+	return DefaultResolver
+}
+
+var resolverKey = "resolver"
+
+var errUnresolved = errors.New("unresolved import")
+
+func assumedName(importPath string) (string, error) {
+	base := path.Base(importPath)
+	if strings.HasPrefix(base, "v") {
+		if _, err := strconv.Atoi(base[1:]); err == nil {
+			if dir := path.Dir(importPath); dir != "." {
+				base = path.Base(dir)
+			}
+		}
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if idx := strings.IndexFunc(base, notIdentifier); idx >= 0 {
+		base = base[:idx]
+	}
+	if base == "" {
+		return "", errUnresolved
+	}
+	return base, nil
+}
+
+func notIdentifier(ch rune) bool {
+	return !(ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch))
+}
+
+func loadName(path string) (string, error) {
+	cfg := &packages.Config{Mode: packages.NeedName}
+	pkgs, err := packages.Load(cfg, path)
+	if err != nil {
+		return "", err
+	}
+	if len(pkgs) != 1 || pkgs[0].Name == "" {
+		return "", errUnresolved
+	}
+	return pkgs[0].Name, nil
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code_test
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"github.com/tvastar/gogo/pkg/code"
+
+	"errors"
+	"testing"
+)
+
+func TestResolvers(t *testing.T) {
+	cases := map[string]string{
+		"strings":                        "strings",
+		"math/rand/v2":                   "rand",
+		"net/http":                       "http",
+		"gopkg.in/yaml.v2":               "yaml",
+		"github.com/mattn/go-sqlite3":    "sqlite3",
+		"github.com/jackc/pgx/v5":        "pgx",
+		"github.com/google/go-cmp/cmp":   "cmp",
+		"github.com/tvastar/gogo/pkg/v2": "v2x",
+	}
+	r := code.Resolvers(
+		code.ImportNames{"github.com/tvastar/gogo/pkg/v2": "v2x"},
+		code.DefaultResolver,
+	)
+	for path, expected := range cases {
+		if name, err := r.ResolveImport(path); err != nil || name != expected {
+			t.Error(path, "unexpected", name, err)
+		}
+	}
+
+	if _, err := code.Stdlib.ResolveImport("gopkg.in/yaml.v2"); err == nil {
+		t.Error("unexpected stdlib resolution")
+	}
+}
+
+func TestCacheResolver(t *testing.T) {
+	calls := 0
+	r := code.Cache(code.ImportResolverFunc(func(path string) (string, error) {
+		calls++
+		if path == "bad" {
+			return "", errors.New("bad")
+		}
+		return "good", nil
+	}))
+
+	for kk := 0; kk < 3; kk++ {
+		r.ResolveImport("x")
+		r.ResolveImport("bad")
+	}
+	if calls != 4 {
+		t.Error("unexpected calls", calls)
+	}
+}
+
+func TestUseImportResolver(t *testing.T) {
+	names := code.ImportNames{"example.com/x/y": "z"}
+	src, err := code.Render(code.UseImportResolver(names, code.File("x",
+		code.Var().WithValue(code.Ident("x"), code.Import("example.com/x/y").Dot("Y"), nil),
+	)))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	expected := "package x\n\nimport \"example.com/x/y\"\n\nvar x z.Y\n"
+	if diff := cmp.Diff(expected, string(src)); diff != "" {
+		t.Error("mismatch", diff)
+	}
+
+	_, err = code.Render(code.UseImportResolver(names, code.File("x",
+		code.Var().WithValue(code.Ident("x"), code.Import("example.com/x").Dot("Y"), nil),
+	)))
+	if err == nil {
+		t.Error("expected unresolved import error")
+	}
+}
+
+func TestImportVariants(t *testing.T) {
+	value := func(name string, typ code.NodeMarshaler) code.NodeMarshaler {
+		return code.Var().WithValue(code.Ident(name), typ, nil)
+	}
+
+	validateRender(t, "alias", "package x\n\nimport sx \"strings\"\n\nvar x sx.Builder\n",
+		code.File("x", value("x", code.ImportAs("sx", "strings").Dot("Builder"))))
+	validateRender(t, "alias reuse", "package x\n\nimport sx \"strings\"\n\nvar x sx.Builder\nvar y sx.Builder\n",
+		code.File("x",
+			value("x", code.ImportAs("sx", "strings").Dot("Builder")),
+			value("y", code.ImportAs("sx", "strings").Dot("Builder"))))
+	validateRender(t, "alias collision", "package x\n\nimport (\n\tsx \"bytes\"\n\tsx1 \"strings\"\n)\n\nvar x sx.Buffer\nvar y sx1.Builder\n",
+		code.File("x",
+			value("x", code.ImportAs("sx", "bytes").Dot("Buffer")),
+			value("y", code.ImportAs("sx", "strings").Dot("Builder"))))
+	validateRender(t, "blank", "package x\n\nimport _ \"embed\"\n",
+		code.File("x", code.ImportBlank("embed")))
+	validateRender(t, "blank and named", "package x\n\nimport (\n\t\"embed\"\n\t_ \"embed\"\n)\n\nvar x embed.FS\n",
+		code.File("x", code.ImportBlank("embed"), value("x", code.Import("embed").Dot("FS"))))
+	validateRender(t, "dot", "package x\n\nimport . \"math\"\n\nvar x = Pi\n",
+		code.File("x", code.ImportDot("math"), code.Var().WithValue(code.Ident("x"), nil, code.Ident("Pi"))))
+	validateRender(t, "var collision", "package x\n\nimport strings1 \"strings\"\n\nvar strings int\nvar x strings1.Builder\n",
+		code.File("x", value("strings", code.Ident("int")), value("x", code.Import("strings").Dot("Builder"))))
+
+	println := code.Import("fmt").Dot("Println")
+	validateRender(t, "shadowed import", "package x\n\nimport (\n\t\"fmt\"\n\tfmt1 \"fmt\"\n)\n\nfunc f() {\n\tfmt.Println()\n\tfmt := 1\n\tfmt1.Println(fmt)\n}\n",
+		code.File("x", code.Func("f").WithBody(
+			println.Call(),
+			code.Assign(":=", code.Ident("fmt"), code.Literal(1)),
+			println.Call(code.Ident("fmt")),
+		)))
+	v := code.IdentPrefix("fmt")
+	validateRender(t, "reserved import", "package x\n\nimport \"fmt\"\n\nfunc f() {\n\tfmt.Println()\n\tfmt2 := 1\n\tfmt.Println(fmt2)\n}\n",
+		code.File("x", code.Func("f").WithBody(
+			println.Call(),
+			code.Assign(":=", v, code.Literal(1)),
+			println.Call(v),
+		)))
+}
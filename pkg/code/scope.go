@@ -33,6 +33,14 @@ func (s *Scope) New() *Scope {
 	}
 }
 
+// root returns the root scope
+func (s *Scope) root() *Scope {
+	for s.Parent != nil {
+		s = s.Parent
+	}
+	return s
+}
+
 // LookupStash looks up the stash (up the parent chain) for a key
 func (s *Scope) LookupStash(key interface{}) (interface{}, bool) {
 	if s == nil {
@@ -55,7 +63,8 @@ func (s *Scope) LookupVar(name string) (ast.Node, bool) {
 	return s.Parent.LookupVar(name)
 }
 
-// PickName picks a unique name with the given prefix
+// PickName picks a unique name with the given prefix.  Names of the
+// imports of the current file are also avoided.
 func (s *Scope) PickName(prefix string) string {
 	if prefix == "" {
 		prefix = "gogox"
@@ -63,7 +72,7 @@ func (s *Scope) PickName(prefix string) string {
 
 	name, idx := prefix, 2
 	for {
-		if _, ok := s.LookupVar(name); !ok {
+		if !isVar(s, name) && !isImport(s, name) {
 			return name
 		}
 		name = prefix + strconv.Itoa(idx)
@@ -0,0 +1,196 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+// stdlib maps the import path of every standard library package to
+// its package name.
+//
+// It was built using:
+//
+//	go list -f '{{.ImportPath}} {{.Name}}' std
+//
+// skipping internal and vendored packages.
+var stdlib = map[string]string{
+	"archive/tar":            "tar",
+	"archive/zip":            "zip",
+	"bufio":                  "bufio",
+	"bytes":                  "bytes",
+	"cmp":                    "cmp",
+	"compress/bzip2":         "bzip2",
+	"compress/flate":         "flate",
+	"compress/gzip":          "gzip",
+	"compress/lzw":           "lzw",
+	"compress/zlib":          "zlib",
+	"container/heap":         "heap",
+	"container/list":         "list",
+	"container/ring":         "ring",
+	"context":                "context",
+	"crypto":                 "crypto",
+	"crypto/aes":             "aes",
+	"crypto/cipher":          "cipher",
+	"crypto/des":             "des",
+	"crypto/dsa":             "dsa",
+	"crypto/ecdh":            "ecdh",
+	"crypto/ecdsa":           "ecdsa",
+	"crypto/ed25519":         "ed25519",
+	"crypto/elliptic":        "elliptic",
+	"crypto/fips140":         "fips140",
+	"crypto/hkdf":            "hkdf",
+	"crypto/hmac":            "hmac",
+	"crypto/hpke":            "hpke",
+	"crypto/md5":             "md5",
+	"crypto/mldsa":           "mldsa",
+	"crypto/mlkem":           "mlkem",
+	"crypto/mlkem/mlkemtest": "mlkemtest",
+	"crypto/pbkdf2":          "pbkdf2",
+	"crypto/rand":            "rand",
+	"crypto/rc4":             "rc4",
+	"crypto/rsa":             "rsa",
+	"crypto/sha1":            "sha1",
+	"crypto/sha256":          "sha256",
+	"crypto/sha3":            "sha3",
+	"crypto/sha512":          "sha512",
+	"crypto/subtle":          "subtle",
+	"crypto/tls":             "tls",
+	"crypto/x509":            "x509",
+	"crypto/x509/pkix":       "pkix",
+	"database/sql":           "sql",
+	"database/sql/driver":    "driver",
+	"debug/buildinfo":        "buildinfo",
+	"debug/dwarf":            "dwarf",
+	"debug/elf":              "elf",
+	"debug/gosym":            "gosym",
+	"debug/macho":            "macho",
+	"debug/pe":               "pe",
+	"debug/plan9obj":         "plan9obj",
+	"embed":                  "embed",
+	"encoding":               "encoding",
+	"encoding/ascii85":       "ascii85",
+	"encoding/asn1":          "asn1",
+	"encoding/base32":        "base32",
+	"encoding/base64":        "base64",
+	"encoding/binary":        "binary",
+	"encoding/csv":           "csv",
+	"encoding/gob":           "gob",
+	"encoding/hex":           "hex",
+	"encoding/json":          "json",
+	"encoding/json/jsontext": "jsontext",
+	"encoding/json/v2":       "json",
+	"encoding/pem":           "pem",
+	"encoding/xml":           "xml",
+	"errors":                 "errors",
+	"expvar":                 "expvar",
+	"flag":                   "flag",
+	"fmt":                    "fmt",
+	"go/ast":                 "ast",
+	"go/build":               "build",
+	"go/build/constraint":    "constraint",
+	"go/constant":            "constant",
+	"go/doc":                 "doc",
+	"go/doc/comment":         "comment",
+	"go/format":              "format",
+	"go/importer":            "importer",
+	"go/parser":              "parser",
+	"go/printer":             "printer",
+	"go/scanner":             "scanner",
+	"go/token":               "token",
+	"go/types":               "types",
+	"go/version":             "version",
+	"hash":                   "hash",
+	"hash/adler32":           "adler32",
+	"hash/crc32":             "crc32",
+	"hash/crc64":             "crc64",
+	"hash/fnv":               "fnv",
+	"hash/maphash":           "maphash",
+	"html":                   "html",
+	"html/template":          "template",
+	"image":                  "image",
+	"image/color":            "color",
+	"image/color/palette":    "palette",
+	"image/draw":             "draw",
+	"image/gif":              "gif",
+	"image/jpeg":             "jpeg",
+	"image/png":              "png",
+	"index/suffixarray":      "suffixarray",
+	"io":                     "io",
+	"io/fs":                  "fs",
+	"io/ioutil":              "ioutil",
+	"iter":                   "iter",
+	"log":                    "log",
+	"log/slog":               "slog",
+	"log/syslog":             "syslog",
+	"maps":                   "maps",
+	"math":                   "math",
+	"math/big":               "big",
+	"math/bits":              "bits",
+	"math/cmplx":             "cmplx",
+	"math/rand":              "rand",
+	"math/rand/v2":           "rand",
+	"mime":                   "mime",
+	"mime/multipart":         "multipart",
+	"mime/quotedprintable":   "quotedprintable",
+	"net":                    "net",
+	"net/http":               "http",
+	"net/http/cgi":           "cgi",
+	"net/http/cookiejar":     "cookiejar",
+	"net/http/fcgi":          "fcgi",
+	"net/http/httptest":      "httptest",
+	"net/http/httptrace":     "httptrace",
+	"net/http/httputil":      "httputil",
+	"net/http/pprof":         "pprof",
+	"net/mail":               "mail",
+	"net/netip":              "netip",
+	"net/rpc":                "rpc",
+	"net/rpc/jsonrpc":        "jsonrpc",
+	"net/smtp":               "smtp",
+	"net/textproto":          "textproto",
+	"net/url":                "url",
+	"os":                     "os",
+	"os/exec":                "exec",
+	"os/signal":              "signal",
+	"os/user":                "user",
+	"path":                   "path",
+	"path/filepath":          "filepath",
+	"plugin":                 "plugin",
+	"reflect":                "reflect",
+	"regexp":                 "regexp",
+	"regexp/syntax":          "syntax",
+	"runtime":                "runtime",
+	"runtime/cgo":            "cgo",
+	"runtime/coverage":       "coverage",
+	"runtime/debug":          "debug",
+	"runtime/metrics":        "metrics",
+	"runtime/pprof":          "pprof",
+	"runtime/race":           "race",
+	"runtime/trace":          "trace",
+	"slices":                 "slices",
+	"sort":                   "sort",
+	"strconv":                "strconv",
+	"strings":                "strings",
+	"structs":                "structs",
+	"sync":                   "sync",
+	"sync/atomic":            "atomic",
+	"syscall":                "syscall",
+	"testing":                "testing",
+	"testing/cryptotest":     "cryptotest",
+	"testing/fstest":         "fstest",
+	"testing/iotest":         "iotest",
+	"testing/quick":          "quick",
+	"testing/slogtest":       "slogtest",
+	"testing/synctest":       "synctest",
+	"text/scanner":           "scanner",
+	"text/tabwriter":         "tabwriter",
+	"text/template":          "template",
+	"text/template/parse":    "parse",
+	"time":                   "time",
+	"time/tzdata":            "tzdata",
+	"unicode":                "unicode",
+	"unicode/utf16":          "utf16",
+	"unicode/utf8":           "utf8",
+	"unique":                 "unique",
+	"unsafe":                 "unsafe",
+	"uuid":                   "uuid",
+	"weak":                   "weak",
+}
@@ -0,0 +1,253 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// For represents a for statement. Any of init, cond or post can be
+// nil. The body is added using WithBody:
+//
+//	code.For(i.Assign(":=", code.Literal(0)), i.Op("<", n), i.Assign("+=", code.Literal(1))).
+//	    WithBody(...)
+func For(init, cond, post NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		result := &ast.ForStmt{Body: &ast.BlockStmt{}}
+		if init != nil {
+			result.Init = stmt(s, init)
+		}
+		if cond != nil {
+			result.Cond = expr(s, cond)
+		}
+		if post != nil {
+			result.Post = stmt(s, post)
+		}
+		return result
+	})
+}
+
+// Range represents a for statement with a range clause. Op can be
+// ":=" or "=". Key and value can be nil and they are added to the
+// scope when declared with ":=":
+//
+//	code.Range(":=", nil, v, items).WithBody(...)
+//
+// The body is added using WithBody.
+func Range(op string, key, value, x NodeMarshaler) NodeMarshaler {
+	tok := token.ILLEGAL
+	switch op {
+	case ":=":
+		tok = token.DEFINE
+	case "=":
+		tok = token.ASSIGN
+	}
+	return nodef(func(s *Scope) ast.Node {
+		result := &ast.RangeStmt{Body: &ast.BlockStmt{}}
+		if key != nil {
+			result.Key = expr(s, key)
+		}
+		if value != nil {
+			result.Value = expr(s, value)
+			if result.Key == nil {
+				result.Key = ast.NewIdent("_")
+			}
+		}
+		result.X = expr(s, x)
+		if result.Key != nil {
+			result.Tok = tok
+			if tok == token.DEFINE {
+				// the range variables are always in a new scope
+				declare(s, nil, result.Key, result.Value)
+				s.declareRange(result.Key, result.Value, result.X)
+			}
+		}
+		return result
+	})
+}
+
+// Switch represents a switch statement. Init and tag can be nil.
+//
+// The clauses are added using WithBody:
+//
+//	code.Switch(nil, x).WithBody(
+//	    code.Case(code.Literal(1)).Then(...),
+//	    code.Default().Then(...),
+//	)
+func Switch(init, tag NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		result := &ast.SwitchStmt{Body: &ast.BlockStmt{}}
+		if init != nil {
+			result.Init = stmt(s, init)
+		}
+		if tag != nil {
+			result.Tag = expr(s, tag)
+		}
+		return result
+	})
+}
+
+// TypeSwitch represents a type switch on x, binding the value to
+// bind in each clause. Bind can be nil. Typically bind is an
+// IdentPrefix so that a fresh variable is used:
+//
+//	code.TypeSwitch(code.IdentPrefix("v"), x).WithBody(
+//	    code.Case(code.Ident("int")).Then(...),
+//	)
+//
+// The clauses are added using WithBody.
+func TypeSwitch(bind, x NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		assert := &ast.TypeAssertExpr{X: expr(s, x)}
+		result := &ast.TypeSwitchStmt{Body: &ast.BlockStmt{}}
+		if bind == nil {
+			result.Assign = &ast.ExprStmt{X: assert}
+			return result
+		}
+
+		name := ident(s, bind)
+		s.Vars[name.Name] = name
+		result.Assign = &ast.AssignStmt{
+			Lhs: []ast.Expr{name},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{assert},
+		}
+		return result
+	})
+}
+
+// Select represents a select statement. The clauses are added using
+// WithBody:
+//
+//	code.Select().WithBody(
+//	    code.Comm(v.Assign(":=", ch.Op("<-", nil))).Then(...),
+//	    code.Default().Then(...),
+//	)
+func Select() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.SelectStmt{Body: &ast.BlockStmt{}}
+	})
+}
+
+// Case represents a case clause in a switch or type switch
+// statement. The body of the clause is added using Then.
+func Case(exprs ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		result := &ast.CaseClause{}
+		for _, x := range exprs {
+			result.List = append(result.List, expr(s, x))
+		}
+		return result
+	})
+}
+
+// Default represents the default clause of a switch or select
+// statement. The body of the clause is added using Then.
+func Default() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.CaseClause{}
+	})
+}
+
+// Comm represents a communication clause of a select statement.
+// The body of the clause is added using Then.
+func Comm(comm NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.CommClause{Comm: stmt(s, comm)}
+	})
+}
+
+// commClauses converts default clauses into comm clauses
+func commClauses(body *ast.BlockStmt) *ast.BlockStmt {
+	for kk, clause := range body.List {
+		if cc, ok := clause.(*ast.CaseClause); ok && len(cc.List) == 0 {
+			body.List[kk] = &ast.CommClause{Body: cc.Body}
+		}
+	}
+	return body
+}
+
+// Go represents a go statement. The call is typically a Call or a
+// Lambda that is called:
+//
+//	code.Go(code.Lambda().WithBody(...).Call())
+func Go(call NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.GoStmt{Call: callExpr(s, call)}
+	})
+}
+
+// Defer represents a defer statement:
+//
+//	code.Defer(f.Dot("Close").Call())
+func Defer(call NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.DeferStmt{Call: callExpr(s, call)}
+	})
+}
+
+// Send represents a channel send statement: ch <- v
+func Send(ch, v NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.SendStmt{Chan: expr(s, ch), Value: expr(s, v)}
+	})
+}
+
+// Recv represents a channel receive expression: <-ch
+func Recv(ch NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.UnaryExpr{Op: token.ARROW, X: expr(s, ch)}
+	})
+}
+
+// RecvOk represents a receive with the comma-ok form:
+//
+//	v, ok := <-ch
+//
+// Op can be ":=" or "=". Either v or ok can be nil, which uses "_"
+// instead.  The variables declared with ":=" are added to the scope.
+func RecvOk(op string, v, ok, ch NodeMarshaler) NodeMarshaler {
+	tok := opToken(op)
+	return nodef(func(s *Scope) ast.Node {
+		existing := varNames(s)
+		lhs := []ast.Expr{blank(s, v), blank(s, ok)}
+		recv := &ast.UnaryExpr{Op: token.ARROW, X: expr(s, ch)}
+		values := []ast.Expr{recv, ast.NewIdent("true")}
+		return assign(s, existing, tok, lhs, []ast.Expr{recv}, values)
+	})
+}
+
+// Inc represents an increment statement: x++
+func Inc(x NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.IncDecStmt{X: expr(s, x), Tok: token.INC}
+	})
+}
+
+// Dec represents a decrement statement: x--
+func Dec(x NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.IncDecStmt{X: expr(s, x), Tok: token.DEC}
+	})
+}
+
+// callExpr marshals the node which is expected to be a call
+func callExpr(s *Scope, m NodeMarshaler) *ast.CallExpr {
+	n := m.MarshalNode(s)
+	if call, ok := n.(*ast.CallExpr); ok {
+		return call
+	}
+	s.unexpected(m, n, "a call")
+	return &ast.CallExpr{Fun: &ast.BadExpr{}}
+}
+
+// blank marshals the expression, using "_" if it is nil
+func blank(s *Scope, m NodeMarshaler) ast.Expr {
+	if m == nil {
+		return ast.NewIdent("_")
+	}
+	return expr(s, m)
+}
@@ -0,0 +1,572 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+// TypeOf returns the type of a variable visible in the scope or nil
+// if it is not known.
+//
+// The type is known for params, results, receivers, variables
+// declared with a type or initialized with a value whose type is
+// known (such as literals, composites and calls of functions with a
+// known signature) and range keys and values.  Types declared with
+// Struct, Interface or Decl are resolved as well as the builtin types.
+// Types from imported packages are only resolved when an importer is
+// configured with UseImporter.
+//
+// Generators can also provide the types with SetType.
+func (s *Scope) TypeOf(name string) types.Type {
+	for ; s != nil; s = s.Parent {
+		if _, ok := s.Vars[name]; ok {
+			if info, ok := s.Stash[&typesKey].(*typeInfo); ok && info.vars[name] != nil {
+				return info.vars[name]()
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// SetType sets the type of the variable in the scope where it is
+// declared. If it is not declared, the current scope is used.
+func (s *Scope) SetType(name string, t types.Type) {
+	target := s
+	for x := s; x != nil; x = x.Parent {
+		if _, ok := x.Vars[name]; ok {
+			target = x
+			break
+		}
+	}
+	target.typeInfo().vars[name] = func() types.Type { return t }
+}
+
+// UseImporter marshals the node using the provided importer to find
+// the types of imported packages:
+//
+//	imp := importer.ForCompiler(token.NewFileSet(), "source", nil)
+//	code.Render(code.UseImporter(imp, code.File(...)))
+func UseImporter(imp types.Importer, n NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		s = s.New()
+		s.Stash[&importerKey] = imp
+		return n.MarshalNode(s)
+	})
+}
+
+var importerKey = "importer"
+
+var typesKey = "types"
+
+// typeInfo holds the lazily computed types of the variables and
+// type names declared in a scope
+type typeInfo struct {
+	vars, names map[string]func() types.Type
+}
+
+func (s *Scope) typeInfo() *typeInfo {
+	if info, ok := s.Stash[&typesKey]; ok {
+		return info.(*typeInfo)
+	}
+	info := &typeInfo{map[string]func() types.Type{}, map[string]func() types.Type{}}
+	s.Stash[&typesKey] = info
+	return info
+}
+
+// declareVar records the type of a variable declared in the scope.
+// Exactly one of typ or value is used to compute the type, on first
+// use.
+func (s *Scope) declareVar(name string, typ, value ast.Expr) {
+	if typ == nil && value == nil {
+		return
+	}
+	s.typeInfo().vars[name] = once(func() types.Type {
+		if typ != nil {
+			return s.typeExpr(typ)
+		}
+		return s.valueType(value)
+	})
+}
+
+// declareRange records the types of the range key and value
+func (s *Scope) declareRange(key, value, x ast.Expr) {
+	elems := func() (types.Type, types.Type) {
+		switch t := under(s.valueType(x)).(type) {
+		case *types.Basic:
+			if t.Info()&types.IsString != 0 {
+				return types.Typ[types.Int], universe("rune")
+			}
+			if t.Info()&types.IsInteger != 0 {
+				return t, nil
+			}
+		case *types.Slice:
+			return types.Typ[types.Int], t.Elem()
+		case *types.Array:
+			return types.Typ[types.Int], t.Elem()
+		case *types.Pointer:
+			if a, ok := under(t.Elem()).(*types.Array); ok {
+				return types.Typ[types.Int], a.Elem()
+			}
+		case *types.Map:
+			return t.Key(), t.Elem()
+		case *types.Chan:
+			return t.Elem(), nil
+		}
+		return nil, nil
+	}
+
+	info := s.typeInfo()
+	if id, ok := key.(*ast.Ident); ok {
+		info.vars[id.Name] = once(func() types.Type { k, _ := elems(); return k })
+	}
+	if id, ok := value.(*ast.Ident); ok {
+		info.vars[id.Name] = once(func() types.Type { _, v := elems(); return v })
+	}
+}
+
+// declareType records a type declared in the scope
+func (s *Scope) declareType(spec *ast.TypeSpec) {
+	var named *types.Named
+	s.typeInfo().names[spec.Name.Name] = func() types.Type {
+		if named == nil {
+			obj := types.NewTypeName(token.NoPos, nil, spec.Name.Name, nil)
+			named = types.NewNamed(obj, nil, nil)
+			u := under(s.typeExpr(spec.Type))
+			if u == nil {
+				u = types.Typ[types.Invalid]
+			}
+			named.SetUnderlying(u)
+		}
+		return named
+	}
+}
+
+// typeName looks up a type declared in the scope chain
+func (s *Scope) typeName(name string) types.Type {
+	for ; s != nil; s = s.Parent {
+		if info, ok := s.Stash[&typesKey].(*typeInfo); ok && info.names[name] != nil {
+			return info.names[name]()
+		}
+		if _, ok := s.Vars[name]; ok {
+			return nil
+		}
+	}
+	return universe(name)
+}
+
+// typeExpr converts a type expression into a type
+func (s *Scope) typeExpr(x ast.Expr) types.Type {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return s.typeName(x.Name)
+	case *ast.ParenExpr:
+		return s.typeExpr(x.X)
+	case *ast.SelectorExpr:
+		if obj := s.importedObject(x); obj != nil {
+			if tn, ok := obj.(*types.TypeName); ok {
+				return tn.Type()
+			}
+		}
+	case *ast.StarExpr:
+		if elt := s.typeExpr(x.X); elt != nil {
+			return types.NewPointer(elt)
+		}
+	case *ast.Ellipsis:
+		if elt := s.typeExpr(x.Elt); elt != nil {
+			return types.NewSlice(elt)
+		}
+	case *ast.ArrayType:
+		elt := s.typeExpr(x.Elt)
+		if elt == nil {
+			return nil
+		}
+		if x.Len == nil {
+			return types.NewSlice(elt)
+		}
+		if lit, ok := x.Len.(*ast.BasicLit); ok && lit.Kind == token.INT {
+			if n, err := strconv.ParseInt(lit.Value, 0, 64); err == nil {
+				return types.NewArray(elt, n)
+			}
+		}
+	case *ast.MapType:
+		k, v := s.typeExpr(x.Key), s.typeExpr(x.Value)
+		if k != nil && v != nil {
+			return types.NewMap(k, v)
+		}
+	case *ast.ChanType:
+		dir := types.SendRecv
+		switch x.Dir {
+		case ast.SEND:
+			dir = types.SendOnly
+		case ast.RECV:
+			dir = types.RecvOnly
+		}
+		if elt := s.typeExpr(x.Value); elt != nil {
+			return types.NewChan(dir, elt)
+		}
+	case *ast.FuncType:
+		if sig := s.signature(x); sig != nil {
+			return sig
+		}
+	case *ast.StructType:
+		var fields []*types.Var
+		for _, f := range x.Fields.List {
+			t := s.typeExpr(f.Type)
+			if t == nil {
+				return nil
+			}
+			if len(f.Names) == 0 {
+				fields = append(fields, types.NewField(token.NoPos, nil, embeddedName(t), t, true))
+			}
+			for _, name := range f.Names {
+				fields = append(fields, types.NewField(token.NoPos, nil, name.Name, t, false))
+			}
+		}
+		return types.NewStruct(fields, nil)
+	case *ast.InterfaceType:
+		var methods []*types.Func
+		var embeds []types.Type
+		for _, f := range x.Methods.List {
+			t := s.typeExpr(f.Type)
+			if t == nil {
+				return nil
+			}
+			if len(f.Names) == 0 {
+				embeds = append(embeds, t)
+			}
+			for _, name := range f.Names {
+				sig, _ := t.(*types.Signature)
+				methods = append(methods, types.NewFunc(token.NoPos, nil, name.Name, sig))
+			}
+		}
+		return types.NewInterfaceType(methods, embeds).Complete()
+	}
+	return nil
+}
+
+// signature converts a func type into a signature. Unnamed params
+// and results are fine but all types must be known.
+func (s *Scope) signature(ft *ast.FuncType) *types.Signature {
+	tuple := func(fl *ast.FieldList) (*types.Tuple, bool) {
+		var vars []*types.Var
+		for _, f := range fl.List {
+			t := s.typeExpr(f.Type)
+			if t == nil {
+				return nil, false
+			}
+			if len(f.Names) == 0 {
+				vars = append(vars, types.NewParam(token.NoPos, nil, "", t))
+			}
+			for _, name := range f.Names {
+				vars = append(vars, types.NewParam(token.NoPos, nil, name.Name, t))
+			}
+		}
+		return types.NewTuple(vars...), true
+	}
+
+	params, variadic := types.NewTuple(), false
+	if ft.Params != nil {
+		var ok bool
+		if params, ok = tuple(ft.Params); !ok {
+			return nil
+		}
+		if n := len(ft.Params.List); n > 0 {
+			_, variadic = ft.Params.List[n-1].Type.(*ast.Ellipsis)
+		}
+	}
+	results := types.NewTuple()
+	if ft.Results != nil {
+		var ok bool
+		if results, ok = tuple(ft.Results); !ok {
+			return nil
+		}
+	}
+	return types.NewSignatureType(nil, nil, nil, params, results, variadic)
+}
+
+// valueType returns the type of a value expression
+func (s *Scope) valueType(x ast.Expr) types.Type {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		switch x.Kind {
+		case token.INT:
+			return types.Typ[types.Int]
+		case token.FLOAT:
+			return types.Typ[types.Float64]
+		case token.IMAG:
+			return types.Typ[types.Complex128]
+		case token.CHAR:
+			return universe("rune")
+		case token.STRING:
+			return types.Typ[types.String]
+		}
+	case *ast.Ident:
+		if _, ok := s.LookupVar(x.Name); ok {
+			return s.TypeOf(x.Name)
+		}
+		if x.Name == "true" || x.Name == "false" {
+			return types.Typ[types.Bool]
+		}
+	case *ast.ParenExpr:
+		return s.valueType(x.X)
+	case *ast.CompositeLit:
+		return s.typeExpr(x.Type)
+	case *ast.FuncLit:
+		return s.typeExpr(x.Type)
+	case *ast.TypeAssertExpr:
+		return s.typeExpr(x.Type)
+	case *ast.UnaryExpr:
+		t := s.valueType(x.X)
+		switch {
+		case t == nil:
+			return nil
+		case x.Op == token.AND:
+			return types.NewPointer(t)
+		case x.Op == token.ARROW:
+			if ch, ok := under(t).(*types.Chan); ok {
+				return ch.Elem()
+			}
+			return nil
+		case x.Op == token.NOT:
+			return types.Typ[types.Bool]
+		}
+		return t
+	case *ast.StarExpr:
+		if p, ok := under(s.valueType(x.X)).(*types.Pointer); ok {
+			return p.Elem()
+		}
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+			return types.Typ[types.Bool]
+		case token.SHL, token.SHR:
+			return s.valueType(x.X)
+		}
+		kx, ky := constKind(x.X), constKind(x.Y)
+		switch {
+		case kx != 0 && ky != 0:
+			return constTypes[max(kx, ky)]()
+		case kx != 0:
+			return s.valueType(x.Y)
+		case ky != 0:
+			return s.valueType(x.X)
+		}
+		if s.valueType(x.Y) == nil {
+			return nil
+		}
+		return s.valueType(x.X)
+	case *ast.IndexExpr:
+		switch t := under(s.valueType(x.X)).(type) {
+		case *types.Basic:
+			if t.Info()&types.IsString != 0 {
+				return types.Typ[types.Byte]
+			}
+		case *types.Slice:
+			return t.Elem()
+		case *types.Array:
+			return t.Elem()
+		case *types.Pointer:
+			if a, ok := under(t.Elem()).(*types.Array); ok {
+				return a.Elem()
+			}
+		case *types.Map:
+			return t.Elem()
+		}
+	case *ast.SliceExpr:
+		t := s.valueType(x.X)
+		if p, ok := under(t).(*types.Pointer); ok {
+			t = p.Elem()
+		}
+		if a, ok := under(t).(*types.Array); ok {
+			return types.NewSlice(a.Elem())
+		}
+		return t
+	case *ast.SelectorExpr:
+		if obj := s.importedObject(x); obj != nil {
+			if _, ok := obj.(*types.TypeName); !ok {
+				return obj.Type()
+			}
+			return nil
+		}
+		if t := s.valueType(x.X); t != nil {
+			obj, _, _ := types.LookupFieldOrMethod(t, true, nil, x.Sel.Name)
+			if obj != nil {
+				return obj.Type()
+			}
+		}
+	case *ast.CallExpr:
+		return s.callType(x)
+	}
+	return nil
+}
+
+// callType returns the type of a call with a single result or a
+// conversion
+func (s *Scope) callType(x *ast.CallExpr) types.Type {
+	if id, ok := x.Fun.(*ast.Ident); ok {
+		if _, isVar := s.LookupVar(id.Name); !isVar {
+			switch id.Name {
+			case "len", "cap", "copy":
+				return types.Typ[types.Int]
+			case "new":
+				if len(x.Args) == 1 {
+					if t := s.typeExpr(x.Args[0]); t != nil {
+						return types.NewPointer(t)
+					}
+				}
+				return nil
+			case "make":
+				if len(x.Args) > 0 {
+					return s.typeExpr(x.Args[0])
+				}
+				return nil
+			case "append":
+				if len(x.Args) > 0 {
+					return s.valueType(x.Args[0])
+				}
+				return nil
+			}
+		}
+	}
+
+	// conversions
+	switch fn := x.Fun.(type) {
+	case *ast.Ident:
+		if t := s.typeName(fn.Name); t != nil {
+			return t
+		}
+	case *ast.ParenExpr, *ast.StarExpr, *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType:
+		if t := s.typeExpr(fn); t != nil {
+			return t
+		}
+	case *ast.SelectorExpr:
+		if obj, ok := s.importedObject(fn).(*types.TypeName); ok {
+			return obj.Type()
+		}
+	}
+
+	if sig, ok := under(s.valueType(x.Fun)).(*types.Signature); ok && sig.Results().Len() == 1 {
+		return sig.Results().At(0).Type()
+	}
+	return nil
+}
+
+// importedObject looks up pkg.Name using the importer
+func (s *Scope) importedObject(x *ast.SelectorExpr) types.Object {
+	id, ok := x.X.(*ast.Ident)
+	if !ok || isVar(s, id.Name) {
+		return nil
+	}
+	imp, ok := s.LookupStash(&importerKey)
+	if !ok {
+		return nil
+	}
+	f, ok := s.LookupStash(&fileKey)
+	if !ok {
+		return nil
+	}
+	for _, spec := range f.(*ast.File).Imports {
+		if spec.Name.Name != id.Name {
+			continue
+		}
+		path, _ := strconv.Unquote(spec.Path.Value)
+		pkg, err := imp.(types.Importer).Import(path)
+		if err != nil {
+			return nil
+		}
+		return pkg.Scope().Lookup(x.Sel.Name)
+	}
+	return nil
+}
+
+// once memoizes the result of fn
+func once(fn func() types.Type) func() types.Type {
+	var result types.Type
+	done := false
+	return func() types.Type {
+		if !done {
+			done = true
+			result = fn()
+		}
+		return result
+	}
+}
+
+// constKind returns the kind of an untyped constant expression made
+// of literals as an index into constTypes, or zero if it is not one
+func constKind(x ast.Expr) int {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		switch x.Kind {
+		case token.INT:
+			return 1
+		case token.CHAR:
+			return 2
+		case token.FLOAT:
+			return 3
+		case token.IMAG:
+			return 4
+		}
+	case *ast.ParenExpr:
+		return constKind(x.X)
+	case *ast.UnaryExpr:
+		if x.Op == token.ADD || x.Op == token.SUB || x.Op == token.XOR {
+			return constKind(x.X)
+		}
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM,
+			token.AND, token.OR, token.XOR, token.AND_NOT:
+			if kx, ky := constKind(x.X), constKind(x.Y); kx != 0 && ky != 0 {
+				return max(kx, ky)
+			}
+		case token.SHL, token.SHR:
+			return constKind(x.X)
+		}
+	}
+	return 0
+}
+
+// constTypes are the default types of the untyped constants, the
+// later kinds winning when mixed
+var constTypes = []func() types.Type{
+	nil,
+	func() types.Type { return types.Typ[types.Int] },
+	func() types.Type { return universe("rune") },
+	func() types.Type { return types.Typ[types.Float64] },
+	func() types.Type { return types.Typ[types.Complex128] },
+}
+
+func universe(name string) types.Type {
+	if tn, ok := types.Universe.Lookup(name).(*types.TypeName); ok {
+		return tn.Type()
+	}
+	return nil
+}
+
+func under(t types.Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	return t.Underlying()
+}
+
+func embeddedName(t types.Type) string {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if n, ok := t.(*types.Named); ok {
+		return n.Obj().Name()
+	}
+	if b, ok := t.(*types.Basic); ok {
+		return b.Name()
+	}
+	return ""
+}
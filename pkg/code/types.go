@@ -0,0 +1,96 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package code
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// SliceOf is the slice type []elt
+func SliceOf(elt NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.ArrayType{Elt: expr(s, elt)}
+	})
+}
+
+// ArrayOf is the array type [len]elt. If len is nil, the array
+// type is [...]elt which is only valid in composite literals.
+func ArrayOf(len, elt NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		var l ast.Expr = &ast.Ellipsis{}
+		if len != nil {
+			l = expr(s, len)
+		}
+		return &ast.ArrayType{Len: l, Elt: expr(s, elt)}
+	})
+}
+
+// MapOf is the map type map[key]value
+func MapOf(key, value NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.MapType{
+			Key:   expr(s, key),
+			Value: expr(s, value),
+		}
+	})
+}
+
+// ChanOf is a channel type with the provided direction.
+//
+// Use ast.SEND for "chan<-", ast.RECV for "<-chan" and ast.SEND |
+// ast.RECV for bidirectional channels.
+func ChanOf(dir ast.ChanDir, elt NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.ChanType{Dir: dir, Value: expr(s, elt)}
+	})
+}
+
+// FuncType is a func type. The params and results are added the
+// same way as with Func:
+//
+//	code.FuncType().
+//	    WithParam(nil, code.Ident("int"), nil).
+//	    WithResult(nil, code.Ident("error"), nil)
+func FuncType() NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.FuncType{
+			Params:  &ast.FieldList{},
+			Results: &ast.FieldList{},
+		}
+	})
+}
+
+// Ellipsis is the type of a variadic param: ...elt
+func Ellipsis(elt NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.Ellipsis{Elt: expr(s, elt)}
+	})
+}
+
+// Union is a union of the provided terms for use as a constraint:
+//
+//	code.Interface("Number").Embed(code.Union(code.Tilde(code.Ident("int")), code.Ident("float64")))
+func Union(terms ...NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		var result ast.Expr
+		for _, term := range terms {
+			x := expr(s, term)
+			if result == nil {
+				result = x
+			} else {
+				result = &ast.BinaryExpr{X: result, Op: token.OR, Y: x}
+			}
+		}
+		return result
+	})
+}
+
+// Tilde is the underlying type term ~t for use in constraints
+func Tilde(t NodeMarshaler) NodeMarshaler {
+	return nodef(func(s *Scope) ast.Node {
+		return &ast.UnaryExpr{Op: token.TILDE, X: expr(s, t)}
+	})
+}
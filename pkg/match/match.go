@@ -31,7 +31,7 @@ func Match(left, right interface{}) bool {
 	switch l := left.(type) {
 	case *ast.FieldList:
 		r, ok := right.(*ast.FieldList)
-		if !ok || len(l.List) != len(r.List) {
+		if !ok || l == nil || r == nil || len(l.List) != len(r.List) {
 			log.Printf("Match failed %#v %#v\n", left, right)
 			return false
 		}
@@ -95,6 +95,9 @@ func Match(left, right interface{}) bool {
 	case *ast.IndexExpr:
 		r, ok := right.(*ast.IndexExpr)
 		return ok && Match(l.X, r.X) && Match(l.Index, r.Index)
+	case *ast.IndexListExpr:
+		r, ok := right.(*ast.IndexListExpr)
+		return ok && Match(l.X, r.X) && Match(&l.Indices, &r.Indices)
 	case *ast.SliceExpr:
 		r, ok := right.(*ast.SliceExpr)
 		return ok && Match(l.X, r.X) && Match(l.Low, r.Low) && Match(l.High, r.High) && Match(l.Max, r.Max)
@@ -124,7 +127,8 @@ func Match(left, right interface{}) bool {
 		return ok && Match(l.Fields, r.Fields)
 	case *ast.FuncType:
 		r, ok := right.(*ast.FuncType)
-		return ok && Match(l.Params, r.Params) && Match(l.Results, r.Results)
+		return ok && Match(l.TypeParams, r.TypeParams) &&
+			Match(l.Params, r.Params) && Match(l.Results, r.Results)
 	case *ast.InterfaceType:
 		r, ok := right.(*ast.InterfaceType)
 		return ok && Match(l.Methods, r.Methods)
@@ -223,7 +227,8 @@ func Match(left, right interface{}) bool {
 			Match(&l.Values, &r.Values) && Match(l.Type, r.Type)
 	case *ast.TypeSpec:
 		r, ok := right.(*ast.TypeSpec)
-		return ok && Match(l.Name, r.Name) && Match(l.Type, r.Type)
+		return ok && Match(l.Name, r.Name) && Match(l.TypeParams, r.TypeParams) &&
+			Match(l.Type, r.Type)
 
 	case *ast.GenDecl:
 		r, ok := right.(*ast.GenDecl)
@@ -5,6 +5,7 @@
 package match_test
 
 import (
+	"go/ast"
 	"go/parser"
 	"go/token"
 	"path"
@@ -29,3 +30,40 @@ func TestMatcherOnItself(t *testing.T) {
 		t.Error("Match did not match itself!")
 	}
 }
+
+func TestMatchGenerics(t *testing.T) {
+	parse := func(src string) *ast.File {
+		f, err := parser.ParseFile(token.NewFileSet(), "x.go", src, 0)
+		if err != nil {
+			t.Fatal("Could not parse", err)
+		}
+		return f
+	}
+
+	generic := `package x
+type Pair[K comparable, V any] struct { Key K; Value V }
+type Number interface { ~int | ~float64 }
+func Sum[T Number](x []T) T { var p Pair[string, T]; _ = p; return x[0] }
+`
+	if !match.Match(parse(generic), parse(generic)) {
+		t.Error("Match did not match generic code")
+	}
+
+	cases := []string{
+		`package x; type Pair[K any, V any] struct { Key K; Value V }`,
+		`package x; type Pair struct { Key int; Value int }`,
+		`package x; func Sum[T any](x []T) {}`,
+		`package x; var p Pair[int, string]`,
+	}
+	others := []string{
+		`package x; type Pair[K any] struct { Key K; Value K }`,
+		`package x; type Pair[K any] struct { Key int; Value int }`,
+		`package x; func Sum(x []T) {}`,
+		`package x; var p Pair[int, int]`,
+	}
+	for kk := range cases {
+		if match.Match(parse(cases[kk]), parse(others[kk])) {
+			t.Error("Unexpected match", cases[kk], others[kk])
+		}
+	}
+}
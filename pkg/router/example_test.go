@@ -4,10 +4,7 @@ import (
 	"github.com/tvastar/gogo/pkg/code"
 	"github.com/tvastar/gogo/pkg/router"
 
-	"bytes"
 	"fmt"
-	"go/format"
-	"go/token"
 	"net/http"
 )
 
@@ -16,13 +13,12 @@ func Example() {
 		router.StatusCode(http.StatusOK),
 	)
 
-	var buf bytes.Buffer
-	node := r.MarshalNode(code.RootScope())
-	if err := format.Node(&buf, &token.FileSet{}, node); err != nil {
+	src, err := code.Render(code.MarshalerFunc(r.MarshalNode))
+	if err != nil {
 		fmt.Println("Unexpected error", err)
 	}
 
-	fmt.Println(buf.String())
+	fmt.Println(string(src))
 
 	// Output:
 	// package example
@@ -33,3 +29,27 @@ func Example() {
 	// 	w.WriteHeader(200)
 	// }
 }
+
+func Example_receiver() {
+	r := router.New("example", "").WithRoutes(
+		router.StatusCode(http.StatusNotFound),
+	)
+	r.WithRoutes(router.Receiver().Dot("log").Call(code.Literal(404)))
+
+	src, err := code.Render(code.MarshalerFunc(r.MarshalNode))
+	if err != nil {
+		fmt.Println("Unexpected error", err)
+	}
+
+	fmt.Println(string(src))
+
+	// Output:
+	// package example
+	//
+	// import "net/http"
+	//
+	// func (rr Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 	w.WriteHeader(404)
+	// 	rr.log(404)
+	// }
+}
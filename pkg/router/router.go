@@ -5,8 +5,6 @@
 package router
 
 import (
-	"strings"
-
 	"go/ast"
 
 	"github.com/tvastar/gogo/pkg/code"
@@ -17,20 +15,18 @@ func New(pkgName, structName string) *Config {
 		structName = "Router"
 	}
 
-	recv := strings.ToLower(string(([]rune(structName))[:1]))
-	if recv == "r" {
-		recv = "rr"
-	}
 	return &Config{
-		Package:  pkgName,
-		Struct:   structName,
-		Receiver: recv,
-		Writer:   "w",
-		Request:  "r",
-		Routes:   nil,
+		Package: pkgName,
+		Struct:  structName,
+		Writer:  "w",
+		Request: "r",
+		Routes:  nil,
 	}
 }
 
+// Config is the configuration of the generated router.  The
+// receiver name is picked by code.Method unless Receiver is set to
+// override it; use the Receiver func to refer to it.
 type Config struct {
 	Package, Struct, Receiver, Writer, Request string
 	Routes                                     []code.NodeMarshaler
@@ -47,8 +43,11 @@ func (c *Config) MarshalNode(s *code.Scope) ast.Node {
 	s.Stash[&cfgKey] = c
 	writer := code.Import("net/http").Dot("ResponseWriter")
 	request := code.Import("net/http").Dot("Request").Star()
-	fn := code.Func("ServeHTTP").
-		WithReceiver(code.Ident(c.Receiver), code.Ident(c.Struct), nil).
+	fn := code.Method(code.Ident(c.Struct), "ServeHTTP")
+	if c.Receiver != "" {
+		fn = code.Func("ServeHTTP").WithReceiver(code.Ident(c.Receiver), code.Ident(c.Struct), nil)
+	}
+	fn = fn.
 		WithParam(code.Ident(c.Writer), writer, nil).
 		WithParam(code.Ident(c.Request), request, nil).
 		WithBody(c.Routes...)
@@ -67,6 +66,16 @@ func Writer() code.NodeMarshaler {
 	})
 }
 
+// Receiver refers to the receiver of the generated ServeHTTP method
+func Receiver() code.NodeMarshaler {
+	return code.MarshalerFunc(func(s *code.Scope) ast.Node {
+		if c := FromScope(s); c.Receiver != "" {
+			return ast.NewIdent(c.Receiver)
+		}
+		return code.Receiver().MarshalNode(s)
+	})
+}
+
 func StatusCode(status int) code.NodeMarshaler {
 	return Writer().Dot("WriteHeader").Call(code.Literal(status))
 }